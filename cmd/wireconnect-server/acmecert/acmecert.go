@@ -0,0 +1,121 @@
+package acmecert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type Config struct {
+	Domains   []string `toml:"domains"`
+	Email     string   `toml:"email"`
+	AcceptTOS bool     `toml:"accept_tos"`             // Must be set to agree to the CA's terms of service
+	CacheDir  string   `toml:"cache_dir"`              // Where certificates and the account key are stored
+	Directory string   `toml:"directory_url"`          // Default: Let's Encrypt
+	CAFile    string   `toml:"directory_ca_file"`      // CA bundle used to reach the directory, e.g. for a local Pebble server
+	HTTPAddr  string   `toml:"http_challenge_address"` // Address to answer HTTP-01 challenges on; empty for TLS-ALPN-01 only
+}
+
+// Manager obtains certificates from an ACME CA when they are first needed and
+// renews them before they expire. Certificates are cached on disk.
+type Manager struct {
+	manager *autocert.Manager
+	conf    Config
+}
+
+func New(conf Config) (*Manager, error) {
+	if len(conf.Domains) == 0 {
+		return nil, errors.New("At least one domain must be specified for ACME")
+	}
+	if !conf.AcceptTOS {
+		return nil, errors.New("The ACME CA's terms of service must be accepted with accept_tos")
+	}
+	if conf.CacheDir == "" {
+		return nil, errors.New("ACME cache directory must be specified")
+	}
+
+	client := &acme.Client{
+		DirectoryURL: conf.Directory,
+	}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+
+	if conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in ACME directory CA file")
+		}
+
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+
+	m := Manager{
+		manager: &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(conf.CacheDir),
+			HostPolicy: autocert.HostWhitelist(conf.Domains...),
+			Email:      conf.Email,
+			Client:     client,
+		},
+		conf: conf,
+	}
+
+	return &m, nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate.
+// It also answers TLS-ALPN-01 challenges.
+func (m *Manager) GetCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	// Clients that do not send SNI, such as ones connecting by IP address,
+	// get the certificate of the first domain
+	if clientHello.ServerName == "" {
+		hello := *clientHello
+		hello.ServerName = m.conf.Domains[0]
+		clientHello = &hello
+	}
+
+	return m.manager.GetCertificate(clientHello)
+}
+
+// NextProtos returns the ALPN protocols needed to answer TLS-ALPN-01 challenges
+func (m *Manager) NextProtos() []string {
+	return []string{"h2", "http/1.1", acme.ALPNProto}
+}
+
+// ServeHTTPChallenges starts answering HTTP-01 challenges in the background.
+// It returns an error if the challenge address cannot be listened on, and does
+// nothing if no address was configured.
+func (m *Manager) ServeHTTPChallenges() error {
+	if m.conf.HTTPAddr == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", m.conf.HTTPAddr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		err := http.Serve(listener, m.manager.HTTPHandler(nil))
+		log.Printf("Stopped serving ACME HTTP challenges: %v\n", err)
+	}()
+
+	return nil
+}
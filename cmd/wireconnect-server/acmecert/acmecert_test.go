@@ -0,0 +1,489 @@
+package acmecert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const testDomain = "vpn.example.com"
+
+// OID of the acmeIdentifier extension in TLS-ALPN-01 challenge certificates
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// fakeCA is a minimal in-process ACME server. Authorizations are validated with
+// TLS-ALPN-01 against challengeAddr, and certificates are signed by its own root.
+// Request signatures are not checked.
+type fakeCA struct {
+	t             *testing.T
+	server        *httptest.Server
+	challengeAddr string
+
+	key  *ecdsa.PrivateKey
+	root *x509.Certificate
+
+	mu      sync.Mutex
+	orders  int
+	issued  int
+	domains map[string]string // Authorization ID -> domain
+	valid   map[string]bool   // Authorization ID -> validated
+	csrs    map[string][]byte // Order ID -> CSR
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake ACME root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &fakeCA{
+		t:       t,
+		key:     key,
+		root:    root,
+		domains: make(map[string]string),
+		valid:   make(map[string]bool),
+		csrs:    make(map[string][]byte),
+	}
+
+	ca.server = httptest.NewTLSServer(http.HandlerFunc(ca.serveHTTP))
+	t.Cleanup(ca.server.Close)
+
+	return ca
+}
+
+// caFile writes the certificate of the directory's HTTPS server to a file
+func (ca *fakeCA) caFile() string {
+	path := filepath.Join(ca.t.TempDir(), "directory-ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.server.Certificate().Raw})
+
+	err := ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+
+	return path
+}
+
+func (ca *fakeCA) url(path string) string {
+	return ca.server.URL + path
+}
+
+func (ca *fakeCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+
+	if r.URL.Path == "/directory" {
+		ca.writeJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   ca.url("/new-nonce"),
+			"newAccount": ca.url("/new-account"),
+			"newOrder":   ca.url("/new-order"),
+			"revokeCert": ca.url("/revoke-cert"),
+			"keyChange":  ca.url("/key-change"),
+		})
+		return
+	}
+	if r.URL.Path == "/new-nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payload, err := jwsPayload(r)
+	if err != nil {
+		ca.writeJSON(w, http.StatusBadRequest, map[string]string{"type": "urn:ietf:params:acme:error:malformed", "detail": err.Error()})
+		return
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/new-account":
+		w.Header().Set("Location", ca.url("/account/1"))
+		ca.writeJSON(w, http.StatusCreated, map[string]string{"status": "valid"})
+	case r.URL.Path == "/new-order":
+		var req struct {
+			Identifiers []struct{ Value string }
+		}
+		json.Unmarshal(payload, &req)
+
+		ca.orders++
+		id := fmt.Sprint(ca.orders)
+		ca.domains[id] = req.Identifiers[0].Value
+
+		w.Header().Set("Location", ca.url("/order/"+id))
+		ca.writeJSON(w, http.StatusCreated, ca.order(id))
+	case len(parts) == 2 && parts[0] == "order":
+		w.Header().Set("Location", ca.url(r.URL.Path))
+		ca.writeJSON(w, http.StatusOK, ca.order(parts[1]))
+	case len(parts) == 2 && parts[0] == "authz":
+		ca.writeJSON(w, http.StatusOK, ca.authz(parts[1]))
+	case len(parts) == 2 && parts[0] == "challenge":
+		err := ca.validate(ca.domains[parts[1]])
+		if err != nil {
+			ca.writeJSON(w, http.StatusForbidden, map[string]string{"type": "urn:ietf:params:acme:error:unauthorized", "detail": err.Error()})
+			return
+		}
+
+		ca.valid[parts[1]] = true
+		ca.writeJSON(w, http.StatusOK, ca.authz(parts[1])["challenges"].([]map[string]string)[0])
+	case len(parts) == 2 && parts[0] == "finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+
+		csr, err := base64.RawURLEncoding.DecodeString(req.CSR)
+		if err != nil || !ca.valid[parts[1]] {
+			ca.writeJSON(w, http.StatusForbidden, map[string]string{"type": "urn:ietf:params:acme:error:orderNotReady"})
+			return
+		}
+		ca.csrs[parts[1]] = csr
+
+		w.Header().Set("Location", ca.url("/order/"+parts[1]))
+		ca.writeJSON(w, http.StatusOK, ca.order(parts[1]))
+	case len(parts) == 2 && parts[0] == "cert":
+		chain, err := ca.sign(ca.csrs[parts[1]])
+		if err != nil {
+			ca.writeJSON(w, http.StatusInternalServerError, map[string]string{"type": "urn:ietf:params:acme:error:serverInternal", "detail": err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(chain)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ca *fakeCA) order(id string) map[string]interface{} {
+	order := map[string]interface{}{
+		"status":         "pending",
+		"identifiers":    []map[string]string{{"type": "dns", "value": ca.domains[id]}},
+		"authorizations": []string{ca.url("/authz/" + id)},
+		"finalize":       ca.url("/finalize/" + id),
+	}
+
+	switch {
+	case ca.csrs[id] != nil:
+		order["status"] = "valid"
+		order["certificate"] = ca.url("/cert/" + id)
+	case ca.valid[id]:
+		order["status"] = "ready"
+	}
+
+	return order
+}
+
+func (ca *fakeCA) authz(id string) map[string]interface{} {
+	status := "pending"
+	if ca.valid[id] {
+		status = "valid"
+	}
+
+	return map[string]interface{}{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": ca.domains[id]},
+		"challenges": []map[string]string{{
+			"type":   "tls-alpn-01",
+			"url":    ca.url("/challenge/" + id),
+			"token":  "token-" + id,
+			"status": status,
+		}},
+	}
+}
+
+// validate connects to the server under test as a TLS-ALPN-01 validator would
+func (ca *fakeCA) validate(domain string) error {
+	conn, err := tls.Dial("tcp", ca.challengeAddr, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{acme.ALPNProto},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != acme.ALPNProto {
+		return fmt.Errorf("negotiated protocol %q", state.NegotiatedProtocol)
+	}
+
+	cert := state.PeerCertificates[0]
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != domain {
+		return fmt.Errorf("challenge certificate is for %v", cert.DNSNames)
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(idPeACMEIdentifier) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("challenge certificate has no acmeIdentifier extension")
+}
+
+func (ca *fakeCA) sign(csrDER []byte) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.issued++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(ca.issued + 1)),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.root, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root.Raw})...)
+	return chain, nil
+}
+
+func (ca *fakeCA) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if status >= 400 {
+		w.Header().Set("Content-Type", "application/problem+json")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (ca *fakeCA) issuedCount() int {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	return ca.issued
+}
+
+// jwsPayload returns the decoded payload of a JWS request body
+func jwsPayload(r *http.Request) ([]byte, error) {
+	var jws struct{ Payload string }
+
+	err := json.NewDecoder(r.Body).Decode(&jws)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.RawURLEncoding.DecodeString(jws.Payload)
+}
+
+// serveTLS answers TLS handshakes with m's certificates, as the wireconnect server does
+func serveTLS(t *testing.T, m *Manager) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     m.NextProtos(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func newTestManager(t *testing.T, ca *fakeCA, cacheDir string) *Manager {
+	t.Helper()
+
+	m, err := New(Config{
+		Domains:   []string{testDomain},
+		AcceptTOS: true,
+		CacheDir:  cacheDir,
+		Directory: ca.url("/directory"),
+		CAFile:    ca.caFile(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func leaf(t *testing.T, cert *tls.Certificate) *x509.Certificate {
+	t.Helper()
+
+	if cert.Leaf != nil {
+		return cert.Leaf
+	}
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
+
+func TestIssueAndCache(t *testing.T) {
+	ca := newFakeCA(t)
+	cacheDir := t.TempDir()
+
+	m := newTestManager(t, ca, cacheDir)
+	ca.challengeAddr = serveTLS(t, m)
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: testDomain})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued := leaf(t, cert)
+	if err := issued.VerifyHostname(testDomain); err != nil {
+		t.Error(err)
+	}
+	if err := issued.CheckSignatureFrom(ca.root); err != nil {
+		t.Errorf("certificate was not issued by the CA: %v", err)
+	}
+	if ca.issuedCount() != 1 {
+		t.Fatalf("CA issued %d certificates, want 1", ca.issuedCount())
+	}
+
+	// Later handshakes are answered from memory
+	cert, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: testDomain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf(t, cert).SerialNumber.Cmp(issued.SerialNumber) != 0 {
+		t.Error("certificate changed between handshakes")
+	}
+
+	// A restarted server loads the certificate from the cache directory
+	restarted := newTestManager(t, ca, cacheDir)
+	cert, err = restarted.GetCertificate(&tls.ClientHelloInfo{ServerName: testDomain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf(t, cert).SerialNumber.Cmp(issued.SerialNumber) != 0 {
+		t.Error("restarted manager did not reuse the cached certificate")
+	}
+	if ca.issuedCount() != 1 {
+		t.Errorf("CA issued %d certificates, want 1", ca.issuedCount())
+	}
+}
+
+func TestNoSNI(t *testing.T) {
+	ca := newFakeCA(t)
+
+	m := newTestManager(t, ca, t.TempDir())
+	ca.challengeAddr = serveTLS(t, m)
+
+	// Clients connecting by IP address do not send a server name
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf(t, cert).VerifyHostname(testDomain); err != nil {
+		t.Error(err)
+	}
+
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	if err == nil {
+		t.Error("obtained a certificate for a domain that is not configured")
+	}
+	if ca.issuedCount() != 1 {
+		t.Errorf("CA issued %d certificates, want 1", ca.issuedCount())
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Config
+	}{
+		{"no domains", Config{AcceptTOS: true, CacheDir: "cache"}},
+		{"terms not accepted", Config{Domains: []string{testDomain}, CacheDir: "cache"}},
+		{"no cache", Config{Domains: []string{testDomain}, AcceptTOS: true}},
+		{"missing CA file", Config{Domains: []string{testDomain}, AcceptTOS: true, CacheDir: "cache", CAFile: "/nonexistent"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(test.conf)
+			if err == nil {
+				t.Error("New() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestServeHTTPChallengesBindFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	m, err := New(Config{
+		Domains:   []string{testDomain},
+		AcceptTOS: true,
+		CacheDir:  t.TempDir(),
+		HTTPAddr:  listener.Addr().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.ServeHTTPChallenges()
+	if err == nil {
+		t.Error("ServeHTTPChallenges() succeeded on an address that is in use")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/acmecert"
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/server"
+	flag "github.com/spf13/pflag"
+)
+
+// fileConfig is the layout of the configuration file
+type fileConfig struct {
+	server.Config
+	TLS tlsFiles `toml:"tls"`
+}
+
+type tlsFiles struct {
+	CertFile string `toml:"cert"`
+	KeyFile  string `toml:"key"`
+
+	ClientCA   string `toml:"client_ca"`   // CA bundle used to verify client certificates
+	ClientAuth string `toml:"client_auth"` // "request" (default) or "require"
+
+	// If present, certificates are obtained from an ACME CA instead of cert and key
+	ACME *acmecert.Config `toml:"acme"`
+}
+
+// clientAuthConfig sets up client certificate verification according to files
+func (files tlsFiles) clientAuthConfig(config *tls.Config) error {
+	if files.ClientCA == "" {
+		if files.ClientAuth != "" {
+			return errors.New("client_auth requires client_ca")
+		}
+		return nil
+	}
+
+	pem, err := ioutil.ReadFile(files.ClientCA)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("No certificates found in client CA file")
+	}
+	config.ClientCAs = pool
+
+	switch files.ClientAuth {
+	case "", "request":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("Invalid client_auth %s", files.ClientAuth)
+	}
+
+	return nil
+}
+
+func newFileConfig() fileConfig {
+	conf := fileConfig{Config: server.NewConfig()}
+	conf.DSN = "file:/var/local/wireconnect.sqlite"
+
+	return conf
+}
+
+// loadConfig reads the configuration file at path, if any, on top of the defaults.
+// Flags that were set on the command line override the values from the file.
+func loadConfig(path string, flags *cmdFlags) (fileConfig, error) {
+	conf := newFileConfig()
+
+	if path != "" {
+		_, err := toml.DecodeFile(path, &conf)
+		if err != nil {
+			return conf, err
+		}
+	}
+
+	flags.apply(&conf)
+
+	// Checked here so that a reload with bad values keeps the running configuration
+	return conf, conf.Validate()
+}
+
+// cmdFlags holds the command-line flags that correspond to configuration file settings
+type cmdFlags struct {
+	set *flag.FlagSet
+
+	address         string
+	keyFile         string
+	certFile        string
+	dsn             string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	flushSessions   bool
+	keepInterfaces  bool
+	shutdownTimeout time.Duration
+	secretKeyFile   string
+	clientCA        string
+	clientAuth      string
+}
+
+func newCmdFlags(set *flag.FlagSet) *cmdFlags {
+	defaults := newFileConfig()
+	f := &cmdFlags{set: set}
+
+	set.StringVarP(&f.address, "address", "a", defaults.Address, "Address to listen on")
+	set.StringVarP(&f.keyFile, "key", "k", "", "Path to keyfile")
+	set.StringVarP(&f.certFile, "cert", "c", "", "Path to certfile")
+	set.StringVarP(&f.dsn, "database", "d", defaults.DSN, "SQLite DSN for wireconnect database")
+	set.DurationVar(&f.readTimeout, "read-timeout", defaults.ReadTimeout, "Maximum duration for reading a request")
+	set.DurationVar(&f.writeTimeout, "write-timeout", defaults.WriteTimeout, "Maximum duration for writing a response")
+	set.DurationVar(&f.idleTimeout, "idle-timeout", defaults.IdleTimeout, "Remove peers without a WireGuard handshake for this long (0 to disable; should exceed 3m)")
+	set.BoolVar(&f.flushSessions, "flush-sessions", false, "Forget peers that were connected when the server last stopped instead of restoring them")
+	set.BoolVar(&f.keepInterfaces, "keep-interfaces", defaults.KeepInterfaces, "Leave WireGuard interfaces and peers in place on shutdown")
+	set.DurationVar(&f.shutdownTimeout, "shutdown-timeout", defaults.ShutdownTimeout, "How long to wait for in-flight requests on shutdown")
+	set.StringVar(&f.clientCA, "client-ca", "", "Path to CA bundle used to verify client certificates")
+	set.StringVar(&f.clientAuth, "client-auth", "request", "Whether client certificates are optional (request) or mandatory (require)")
+	set.StringVar(&f.secretKeyFile, "secret-key-file", "", "Path to file containing base64-encoded key used to encrypt interface private keys")
+
+	return f
+}
+
+func (f *cmdFlags) apply(conf *fileConfig) {
+	changed := f.set.Changed
+
+	if changed("address") {
+		conf.Address = f.address
+	}
+	if changed("key") {
+		conf.TLS.KeyFile = f.keyFile
+	}
+	if changed("cert") {
+		conf.TLS.CertFile = f.certFile
+	}
+	if changed("database") {
+		conf.DSN = f.dsn
+	}
+	if changed("read-timeout") {
+		conf.ReadTimeout = f.readTimeout
+	}
+	if changed("write-timeout") {
+		conf.WriteTimeout = f.writeTimeout
+	}
+	if changed("idle-timeout") {
+		conf.IdleTimeout = f.idleTimeout
+	}
+	if changed("flush-sessions") {
+		conf.RestoreSessions = !f.flushSessions
+	}
+	if changed("keep-interfaces") {
+		conf.KeepInterfaces = f.keepInterfaces
+	}
+	if changed("shutdown-timeout") {
+		conf.ShutdownTimeout = f.shutdownTimeout
+	}
+	if changed("secret-key-file") {
+		conf.SecretKeyFile = f.secretKeyFile
+	}
+	if changed("client-ca") {
+		conf.TLS.ClientCA = f.clientCA
+	}
+	if changed("client-auth") {
+		conf.TLS.ClientAuth = f.clientAuth
+	}
+}
+
+// staticChanges returns the names of settings that differ between old and new
+// but only take effect when the server is restarted
+func staticChanges(old, new fileConfig) []string {
+	names := []string{}
+
+	if old.Address != new.Address {
+		names = append(names, "address")
+	}
+	if old.DSN != new.DSN {
+		names = append(names, "database")
+	}
+	if old.SecretKeyFile != new.SecretKeyFile {
+		names = append(names, "secret_key_file")
+	}
+	if old.ReadTimeout != new.ReadTimeout {
+		names = append(names, "read_timeout")
+	}
+	if old.WriteTimeout != new.WriteTimeout {
+		names = append(names, "write_timeout")
+	}
+	if old.ReapInterval != new.ReapInterval {
+		names = append(names, "reap_interval")
+	}
+	if old.RestoreSessions != new.RestoreSessions {
+		names = append(names, "restore_sessions")
+	}
+	if old.ClientCertUser != new.ClientCertUser {
+		names = append(names, "client_cert_user")
+	}
+	if !reflect.DeepEqual(old.Auth, new.Auth) {
+		names = append(names, "auth")
+	}
+	if old.TLS.ClientCA != new.TLS.ClientCA || old.TLS.ClientAuth != new.TLS.ClientAuth {
+		names = append(names, "tls.client_ca/tls.client_auth")
+	}
+	if !reflect.DeepEqual(old.TLS.ACME, new.TLS.ACME) {
+		names = append(names, "tls.acme")
+	}
+
+	return names
+}
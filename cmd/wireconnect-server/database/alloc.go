@@ -0,0 +1,164 @@
+package database
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/sector-f/wireconnect"
+)
+
+var (
+	ErrInvalidAddress    = errors.New("Invalid peer address")
+	ErrTooManyAddresses  = errors.New("A peer can have at most one IPv4 and one IPv6 address")
+	ErrAddressInUse      = errors.New("Address is already assigned to another peer")
+	ErrAddressOutOfRange = errors.New("Address is not within any of the interface's networks")
+	ErrNoFreeAddress     = errors.New("No free addresses remain on the interface")
+)
+
+// usedAddresses returns the addresses assigned to the server's interfaces and to all peers
+// other than the named one.
+func (s *ServiceDB) usedAddresses(username, peername string) (map[string]bool, error) {
+	used := make(map[string]bool)
+
+	rows, err := s.db.Query(`SELECT address FROM server_addresses`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var addr net.IP
+		if err := rows.Scan(&addr); err != nil {
+			return nil, err
+		}
+		used[addr.String()] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	peerRows, err := s.db.Query(
+		`SELECT peers.address, peers.address6
+		FROM peers
+		INNER JOIN users ON users.id = peers.user_id
+		WHERE NOT (users.username = ? AND peers.name = ?)`,
+		username,
+		peername,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer peerRows.Close()
+
+	for peerRows.Next() {
+		var (
+			addr  net.IP
+			addr6 []byte
+		)
+		if err := peerRows.Scan(&addr, &addr6); err != nil {
+			return nil, err
+		}
+		used[addr.String()] = true
+
+		if addr6 != nil {
+			used[net.IP(addr6).String()] = true
+		}
+	}
+
+	return used, peerRows.Err()
+}
+
+// checkPeerAddress verifies that addr lies within one of the interface's networks
+// and is not used by anything other than the named peer.
+func (s *ServiceDB) checkPeerAddress(iface *DBIface, addr net.IP, username, peername string) error {
+	inRange := false
+	for _, ifaceAddr := range iface.Addresses {
+		network := net.IPNet{IP: ifaceAddr.Address.Mask(ifaceAddr.Mask), Mask: ifaceAddr.Mask}
+		if network.Contains(addr) {
+			inRange = true
+			break
+		}
+	}
+	if !inRange {
+		return ErrAddressOutOfRange
+	}
+
+	used, err := s.usedAddresses(username, peername)
+	if err != nil {
+		return err
+	}
+	if used[addr.String()] {
+		return ErrAddressInUse
+	}
+
+	return nil
+}
+
+// hasFamily reports whether the interface has an IPv6 (or IPv4) network
+func (iface *DBIface) hasFamily(ipv6 bool) bool {
+	for _, addr := range iface.Addresses {
+		if (addr.Address.To4() == nil) == ipv6 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allocateAddress returns the first free IPv6 (or IPv4) host address in the interface's networks
+func (s *ServiceDB) allocateAddress(iface *DBIface, ipv6 bool) (wireconnect.Address, error) {
+	used, err := s.usedAddresses("", "")
+	if err != nil {
+		return wireconnect.Address{}, err
+	}
+
+	for _, ifaceAddr := range iface.Addresses {
+		network := net.IPNet{IP: ifaceAddr.Address.Mask(ifaceAddr.Mask), Mask: ifaceAddr.Mask}
+		isIPv4 := network.IP.To4() != nil
+		if isIPv4 == ipv6 {
+			continue
+		}
+
+		for ip := nextIP(network.IP); network.Contains(ip); ip = nextIP(ip) {
+			// Skip the IPv4 broadcast address
+			if isIPv4 && !network.Contains(nextIP(ip)) {
+				break
+			}
+
+			if !used[ip.String()] {
+				return wireconnect.Address{Address: ip.To16(), Mask: ifaceAddr.Mask}, nil
+			}
+		}
+	}
+
+	return wireconnect.Address{}, ErrNoFreeAddress
+}
+
+// addressConflict converts a violation of the unique address indexes into ErrAddressInUse.
+// Addresses are checked before peers are stored, so this only happens when two requests
+// claim the same address at the same time.
+func addressConflict(err error) error {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique && strings.Contains(sqliteErr.Error(), "peers.address") {
+		return ErrAddressInUse
+	}
+
+	return err
+}
+
+// nextIP returns the address following ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// APIKey is a long-lived credential belonging to a user.
+// Only a hash of the key's secret is stored.
+type APIKey struct {
+	ID        string
+	Name      string
+	Username  string
+	Hash      string
+	Scopes    []string // Empty means unrestricted
+	CreatedAt time.Time
+}
+
+func (s *ServiceDB) AddAPIKey(key APIKey) error {
+	_, err := s.db.Exec(
+		`INSERT INTO api_keys (id, user_id, name, hash, scopes, created_at)
+		VALUES (?, (SELECT id FROM users WHERE username = ?), ?, ?, ?, ?)`,
+		key.ID,
+		key.Username,
+		key.Name,
+		key.Hash,
+		strings.Join(key.Scopes, ","),
+		key.CreatedAt.Unix(),
+	)
+
+	return err
+}
+
+func (s *ServiceDB) GetAPIKey(id string) (*APIKey, error) {
+	row := s.db.QueryRow(
+		`SELECT api_keys.id, api_keys.name, users.username, api_keys.hash, api_keys.scopes, api_keys.created_at
+		FROM api_keys
+		INNER JOIN users ON users.id = api_keys.user_id
+		WHERE api_keys.id = ?`,
+		id,
+	)
+
+	return scanAPIKey(row)
+}
+
+// ListAPIKeys returns the API keys of username, or of every user if username is empty
+func (s *ServiceDB) ListAPIKeys(username string) ([]APIKey, error) {
+	rows, err := s.db.Query(
+		`SELECT api_keys.id, api_keys.name, users.username, api_keys.hash, api_keys.scopes, api_keys.created_at
+		FROM api_keys
+		INNER JOIN users ON users.id = api_keys.user_id
+		WHERE ? = '' OR users.username = ?
+		ORDER BY users.username, api_keys.name`,
+		username,
+		username,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey deletes an API key. If username is not empty, the key must belong to that user.
+func (s *ServiceDB) DeleteAPIKey(id, username string) error {
+	result, err := s.db.Exec(
+		`DELETE FROM api_keys
+		WHERE id = ?
+		AND (? = '' OR user_id = (SELECT id FROM users WHERE username = ?))`,
+		id,
+		username,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func scanAPIKey(row scanner) (*APIKey, error) {
+	var (
+		key     APIKey
+		scopes  string
+		created int64
+	)
+
+	err := row.Scan(&key.ID, &key.Name, &key.Username, &key.Hash, &scopes, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	key.CreatedAt = time.Unix(created, 0)
+
+	return &key, nil
+}
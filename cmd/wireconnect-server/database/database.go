@@ -2,14 +2,18 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"log"
+	"net"
 )
 
 type ServiceDB struct {
-	db *sql.DB
+	db        *sql.DB
+	secretKey *[32]byte
 }
 
 func New(db *sql.DB) (*ServiceDB, error) {
-	s := ServiceDB{db}
+	s := ServiceDB{db: db}
 
 	err := s.initDB()
 	if err != nil {
@@ -24,6 +28,10 @@ func (s *ServiceDB) initDB() error {
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	name TEXT UNIQUE NOT NULL,
 	create_on_startup BOOLEAN NOT NULL DEFAULT true,
+	listen_port INTEGER NOT NULL DEFAULT 0,
+	private_key TEXT,
+	next_private_key TEXT,
+	key_rotation_time INTEGER,
 	UNIQUE(name)
 );
 
@@ -47,7 +55,11 @@ CREATE TABLE IF NOT EXISTS users (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	username TEXT UNIQUE NOT NULL,
 	password TEXT NOT NULL,
-	is_admin BOOLEAN NOT NULL DEFAULT false
+	is_admin BOOLEAN NOT NULL DEFAULT false,
+	max_session_lifetime INTEGER NOT NULL DEFAULT 0,
+	totp_secret TEXT,
+	totp_pending_secret TEXT,
+	totp_last_step INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS peers (
@@ -55,14 +67,176 @@ CREATE TABLE IF NOT EXISTS peers (
 	name TEXT NOT NULL,
 	address INTEGER NOT NULL,
 	mask INTEGER NOT NULL,
+	address6 INTEGER,
+	mask6 INTEGER,
 	endpoint_address INTEGER NOT NULL,
 	server_interface_id INTEGER NOT NULL,
 	user_id INTEGER NOT NULL,
 	FOREIGN KEY(server_interface_id) REFERENCES server_interfaces(id),
 	FOREIGN KEY(user_id) REFERENCES users(id),
 	UNIQUE(name, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	peer_id INTEGER UNIQUE NOT NULL,
+	public_key TEXT NOT NULL,
+	connected_at INTEGER NOT NULL,
+	source_address TEXT NOT NULL,
+	FOREIGN KEY(peer_id) REFERENCES peers(id)
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	issued_at INTEGER NOT NULL,
+	access_expiry INTEGER NOT NULL,
+	refresh_expiry INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	hash TEXT NOT NULL,
+	scopes TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	UNIQUE(user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	hash TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS signing_keys (
+	name TEXT PRIMARY KEY,
+	key TEXT NOT NULL
 );`,
 	)
+	if err != nil {
+		return err
+	}
+
+	return s.migrate()
+}
+
+// migrate updates databases created by older versions
+func (s *ServiceDB) migrate() error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"server_interfaces", "private_key", "TEXT"},
+		{"server_interfaces", "next_private_key", "TEXT"},
+		{"server_interfaces", "key_rotation_time", "INTEGER"},
+		{"server_interfaces", "listen_port", "INTEGER NOT NULL DEFAULT 0"},
+		{"peers", "address6", "INTEGER"},
+		{"peers", "mask6", "INTEGER"},
+		{"users", "max_session_lifetime", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "totp_secret", "TEXT"},
+		{"users", "totp_pending_secret", "TEXT"},
+		{"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+	}
+
+	for _, c := range columns {
+		err := s.addColumn(c.table, c.column, c.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Older versions allowed peers to share an address. The unique indexes cannot be
+	// created while duplicates remain, so report them and let the admin resolve them.
+	duplicates, err := s.duplicateAddresses()
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		log.Println("The following peers share addresses. Give each of them a unique address with modify-peer and restart the server to enforce unique addresses:")
+		for _, duplicate := range duplicates {
+			log.Printf("\t%s\n", duplicate)
+		}
+		return nil
+	}
+
+	_, err = s.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS peers_address ON peers(address);
+CREATE UNIQUE INDEX IF NOT EXISTS peers_address6 ON peers(address6);`,
+	)
+	return err
+}
+
+// duplicateAddresses describes each peer whose address is also assigned to another peer
+func (s *ServiceDB) duplicateAddresses() ([]string, error) {
+	rows, err := s.db.Query(
+		`SELECT users.username, peers.name, peers.address
+		FROM peers
+		INNER JOIN users ON users.id = peers.user_id
+		WHERE peers.address IN (SELECT address FROM peers GROUP BY address HAVING COUNT(*) > 1)
+		UNION ALL
+		SELECT users.username, peers.name, peers.address6
+		FROM peers
+		INNER JOIN users ON users.id = peers.user_id
+		WHERE peers.address6 IN (SELECT address6 FROM peers WHERE address6 IS NOT NULL GROUP BY address6 HAVING COUNT(*) > 1)
+		ORDER BY 3`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	duplicates := []string{}
+	for rows.Next() {
+		var (
+			username string
+			peername string
+			addr     net.IP
+		)
+		if err := rows.Scan(&username, &peername, &addr); err != nil {
+			return nil, err
+		}
+
+		duplicates = append(duplicates, fmt.Sprintf("%s/%s: %s", username, peername, addr))
+	}
+
+	return duplicates, rows.Err()
+}
+
+func (s *ServiceDB) addColumn(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue interface{}
+			pk        int
+		)
+
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
 
+	_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
 	return err
 }
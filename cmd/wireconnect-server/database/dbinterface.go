@@ -2,15 +2,34 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"net"
+	"time"
 
 	"github.com/sector-f/wireconnect"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
+var (
+	ErrIfaceInUse     = errors.New("Interface is still used by one or more peers")
+	ErrPeerOutOfRange = errors.New("One or more peers have addresses outside the interface's new networks")
+)
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 type DBIface struct {
 	Name            string
 	CreateOnStartup bool
+	ListenPort      int // 0 lets the kernel choose a port
 	Addresses       []wireconnect.Address
+	PrivateKey      *wgtypes.Key
+	NextPrivateKey  *wgtypes.Key // Replaces PrivateKey at KeyRotationTime
+	KeyRotationTime time.Time
 }
 
 func (s *ServiceDB) Interface(name string) (*DBIface, error) {
@@ -73,8 +92,10 @@ func (s *ServiceDB) IfaceCount() (uint, error) {
 
 func (s *ServiceDB) AddIface(iface DBIface) error {
 	_, err := s.db.Exec(
-		`INSERT OR IGNORE INTO server_interfaces (name) VALUES (?)`,
+		`INSERT OR IGNORE INTO server_interfaces (name, create_on_startup, listen_port) VALUES (?, ?, ?)`,
 		iface.Name,
+		iface.CreateOnStartup,
+		iface.ListenPort,
 	)
 	if err != nil {
 		return err
@@ -84,8 +105,12 @@ func (s *ServiceDB) AddIface(iface DBIface) error {
 	row := s.db.QueryRow(`SELECT id FROM server_interfaces WHERE name = ?`, iface.Name)
 	row.Scan(&ifaceID)
 
-	for _, addr := range iface.Addresses {
-		_, err = s.db.Exec(
+	return addIfaceAddresses(s.db, ifaceID, iface.Addresses)
+}
+
+func addIfaceAddresses(db execer, ifaceID int, addresses []wireconnect.Address) error {
+	for _, addr := range addresses {
+		_, err := db.Exec(
 			`INSERT OR IGNORE INTO server_addresses (address, mask) VALUES (?, ?)`,
 			addr.Address,
 			addr.Mask,
@@ -95,10 +120,10 @@ func (s *ServiceDB) AddIface(iface DBIface) error {
 		}
 
 		var addrID int
-		row := s.db.QueryRow(`SELECT id FROM server_addresses WHERE address = ? AND mask = ?`, addr.Address, addr.Mask)
+		row := db.QueryRow(`SELECT id FROM server_addresses WHERE address = ? AND mask = ?`, addr.Address, addr.Mask)
 		row.Scan(&addrID)
 
-		_, err = s.db.Exec(
+		_, err = db.Exec(
 			`INSERT OR IGNORE INTO server_interface_addresses (interface_id, address_id) VALUES (?, ?)`,
 			ifaceID,
 			addrID,
@@ -111,19 +136,240 @@ func (s *ServiceDB) AddIface(iface DBIface) error {
 	return nil
 }
 
+// ModifyIface changes the named interface. Empty addresses and nil createOnStartup or
+// listenPort are left unchanged. New addresses must still contain every peer's addresses.
+func (s *ServiceDB) ModifyIface(name string, addresses []wireconnect.Address, createOnStartup *bool, listenPort *int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ifaceID int
+	row := tx.QueryRow(`SELECT id FROM server_interfaces WHERE name = ?`, name)
+	err = row.Scan(&ifaceID)
+	if err != nil {
+		return err
+	}
+
+	if len(addresses) > 0 {
+		err = checkIfacePeers(tx, ifaceID, addresses)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM server_interface_addresses WHERE interface_id = ?`, ifaceID)
+		if err != nil {
+			return err
+		}
+
+		err = addIfaceAddresses(tx, ifaceID, addresses)
+		if err != nil {
+			return err
+		}
+
+		err = purgeAddresses(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if createOnStartup != nil {
+		_, err = tx.Exec(`UPDATE server_interfaces SET create_on_startup = ? WHERE id = ?`, *createOnStartup, ifaceID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if listenPort != nil {
+		_, err = tx.Exec(`UPDATE server_interfaces SET listen_port = ? WHERE id = ?`, *listenPort, ifaceID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// checkIfacePeers returns ErrPeerOutOfRange if any peer of the interface has an
+// address outside of the given networks
+func checkIfacePeers(db execer, ifaceID int, addresses []wireconnect.Address) error {
+	rows, err := db.Query(`SELECT address, address6 FROM peers WHERE server_interface_id = ?`, ifaceID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	inRange := func(ip net.IP) bool {
+		for _, addr := range addresses {
+			network := net.IPNet{IP: addr.Address.Mask(addr.Mask), Mask: addr.Mask}
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for rows.Next() {
+		var (
+			addr  net.IP
+			addr6 []byte
+		)
+		if err := rows.Scan(&addr, &addr6); err != nil {
+			return err
+		}
+
+		if !inRange(addr) || (addr6 != nil && !inRange(net.IP(addr6))) {
+			return ErrPeerOutOfRange
+		}
+	}
+
+	return rows.Err()
+}
+
+// SetIfacePrivateKey sets the private key of the named interface and cancels any pending key rotation
+func (s *ServiceDB) SetIfacePrivateKey(name string, key wgtypes.Key) error {
+	sealed, err := s.sealKey(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(
+		`UPDATE server_interfaces
+		SET private_key = ?, next_private_key = NULL, key_rotation_time = NULL
+		WHERE name = ?`,
+		sealed,
+		name,
+	)
+
+	return err
+}
+
+// ScheduleIfaceKeyRotation stores a private key that will replace the named interface's key at the given time
+func (s *ServiceDB) ScheduleIfaceKeyRotation(name string, key wgtypes.Key, at time.Time) error {
+	sealed, err := s.sealKey(key)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(
+		`UPDATE server_interfaces
+		SET next_private_key = ?, key_rotation_time = ?
+		WHERE name = ?`,
+		sealed,
+		at.Unix(),
+		name,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteIface deletes the named interface. It returns ErrIfaceInUse if any peers still use it.
+func (s *ServiceDB) DeleteIface(name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ifaceID int
+	row := tx.QueryRow(`SELECT id FROM server_interfaces WHERE name = ?`, name)
+	err = row.Scan(&ifaceID)
+	if err != nil {
+		return err
+	}
+
+	// Checking for peers in the same statement keeps a peer from being added in between
+	result, err := tx.Exec(
+		`DELETE FROM server_interfaces
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM peers WHERE server_interface_id = ?)`,
+		ifaceID,
+		ifaceID,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrIfaceInUse
+	}
+
+	_, err = tx.Exec(`DELETE FROM server_interface_addresses WHERE interface_id = ?`, ifaceID)
+	if err != nil {
+		return err
+	}
+
+	err = purgeAddresses(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// purgeAddresses deletes addresses that are no longer assigned to any interface
+func purgeAddresses(db execer) error {
+	_, err := db.Exec(
+		`DELETE FROM server_addresses
+		WHERE id NOT IN (SELECT address_id FROM server_interface_addresses)`,
+	)
+	return err
+}
+
 func (s *ServiceDB) getIfaceFromID(id int) (*DBIface, error) {
 	row := s.db.QueryRow(
-		`SELECT name, create_on_startup FROM server_interfaces WHERE id = ?`,
+		`SELECT name, create_on_startup, listen_port, private_key, next_private_key, key_rotation_time
+		FROM server_interfaces
+		WHERE id = ?`,
 		id,
 	)
 
 	iface := DBIface{}
 
-	err := row.Scan(&iface.Name, &iface.CreateOnStartup)
+	var (
+		privKey         sql.NullString
+		nextPrivKey     sql.NullString
+		keyRotationTime sql.NullInt64
+	)
+
+	err := row.Scan(&iface.Name, &iface.CreateOnStartup, &iface.ListenPort, &privKey, &nextPrivKey, &keyRotationTime)
 	if err != nil {
 		return nil, err
 	}
 
+	if privKey.Valid {
+		iface.PrivateKey, err = s.openKey(privKey.String)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if nextPrivKey.Valid {
+		iface.NextPrivateKey, err = s.openKey(nextPrivKey.String)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if keyRotationTime.Valid {
+		iface.KeyRotationTime = time.Unix(keyRotationTime.Int64, 0)
+	}
+
 	rows, err := s.db.Query(
 		`SELECT address, mask
 			FROM       server_addresses           sa
@@ -1,59 +1,108 @@
 package database
 
 import (
+	"database/sql"
 	"errors"
 	"net"
+	"strings"
 
 	"github.com/sector-f/wireconnect"
 )
 
 type PeerConfig struct {
 	Name            string
-	Address         wireconnect.Address
+	Owner           string
+	Addresses       []wireconnect.Address // At most one IPv4 and one IPv6 address
 	EndpointAddress net.IP
 	DBIface         *DBIface
 }
 
+// CreatePeer creates a peer configuration and returns the peer's addresses.
+// peer.Address is a comma-separated list holding at most one IPv4 and one IPv6 address.
+// For each address family that was not requested, the next free address on the peer's
+// interface is allocated if the interface has a network of that family.
 // TODO: make this return different errors; propogate through createPeerHandler to client
-// e.g. user/interface does not exist, address isn't valid address in CIDR notation
-func (s *ServiceDB) CreatePeer(peer wireconnect.CreatePeerRequest) error {
-	peerAddr, err := wireconnect.ParseAddress(peer.Address)
+// e.g. user does not exist
+func (s *ServiceDB) CreatePeer(peer wireconnect.CreatePeerRequest) ([]wireconnect.Address, error) {
+	endpointHost := net.ParseIP(peer.EndpointAddress)
+	if endpointHost == nil {
+		return nil, errors.New("Invalid endpoint host address")
+	}
+
+	iface, err := s.Interface(peer.ServerInterface)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	endpointHost := net.ParseIP(peer.EndpointAddress)
-	if endpointHost == nil {
-		return errors.New("Invalid endpoint host address")
+	var requested []wireconnect.Address
+	if peer.Address != "" {
+		requested, err = parsePeerAddresses(peer.Address)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	v4, v6 := splitFamilies(requested)
+	if v4 == nil && iface.hasFamily(false) {
+		addr, err := s.allocateAddress(iface, false)
+		if err != nil {
+			return nil, err
+		}
+		v4 = &addr
+	}
+	if v6 == nil && iface.hasFamily(true) {
+		addr, err := s.allocateAddress(iface, true)
+		if err != nil {
+			return nil, err
+		}
+		v6 = &addr
+	}
+
+	peerAddrs := joinFamilies(v4, v6)
+	if len(peerAddrs) == 0 {
+		return nil, ErrNoFreeAddress
+	}
+
+	for _, addr := range requested {
+		err = s.checkPeerAddress(iface, addr.Address, peer.UserName, peer.PeerName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addr6, mask6 := secondaryAddress(peerAddrs)
+
 	_, err = s.db.Exec(
-		`INSERT INTO peers (name, address, mask, endpoint_address, server_interface_id, user_id)
+		`INSERT INTO peers (name, address, mask, address6, mask6, endpoint_address, server_interface_id, user_id)
 		VALUES (
 			?,
 			?,
 			?,
 			?,
+			?,
+			?,
 			(SELECT id FROM server_interfaces WHERE name = ?),
 			(SELECT id FROM users WHERE username = ?)
 		)`,
 		peer.PeerName,
-		peerAddr.Address,
-		peerAddr.Mask,
+		peerAddrs[0].Address,
+		peerAddrs[0].Mask,
+		addr6,
+		mask6,
 		endpointHost,
 		peer.ServerInterface,
 		peer.UserName,
 	)
 	if err != nil {
-		return err
+		return nil, addressConflict(err)
 	}
 
-	return nil
+	return peerAddrs, nil
 }
 
 func (s *ServiceDB) GetPeer(username, peername string) *PeerConfig {
 	row := s.db.QueryRow(
-		`SELECT address, mask, endpoint_address, server_interface_id
+		`SELECT address, mask, address6, mask6, endpoint_address, server_interface_id
 		FROM peers
 		WHERE user_id = (SELECT id FROM users WHERE username = ?)
 		AND name = ?`,
@@ -63,15 +112,22 @@ func (s *ServiceDB) GetPeer(username, peername string) *PeerConfig {
 
 	var (
 		addr         wireconnect.Address
+		addr6        []byte
+		mask6        []byte
 		endpointAddr net.IP
 		ifaceID      int
 	)
 
-	err := row.Scan(&addr.Address, &addr.Mask, &endpointAddr, &ifaceID)
+	err := row.Scan(&addr.Address, &addr.Mask, &addr6, &mask6, &endpointAddr, &ifaceID)
 	if err != nil {
 		return nil
 	}
 
+	addrs := []wireconnect.Address{addr}
+	if addr6 != nil {
+		addrs = append(addrs, wireconnect.Address{Address: net.IP(addr6), Mask: net.IPMask(mask6)})
+	}
+
 	iface, err := s.getIfaceFromID(ifaceID)
 	if err != nil {
 		return nil
@@ -79,7 +135,8 @@ func (s *ServiceDB) GetPeer(username, peername string) *PeerConfig {
 
 	return &PeerConfig{
 		Name:            peername,
-		Address:         addr,
+		Owner:           username,
+		Addresses:       addrs,
 		EndpointAddress: endpointAddr,
 		DBIface:         iface,
 	}
@@ -122,7 +179,7 @@ func (s *ServiceDB) ListPeers(username string) *[]wireconnect.Peer {
 			peers,
 			wireconnect.Peer{
 				Name:            config.Name,
-				Address:         config.Address.String(),
+				Address:         AddressList(config.Addresses),
 				EndpointAddress: config.EndpointAddress.String(),
 				ServerInterface: config.DBIface.Name,
 			},
@@ -131,3 +188,210 @@ func (s *ServiceDB) ListPeers(username string) *[]wireconnect.Peer {
 
 	return &peers
 }
+
+// ListAllPeers returns the peer configurations of every user
+func (s *ServiceDB) ListAllPeers() ([]PeerConfig, error) {
+	rows, err := s.db.Query(
+		`SELECT users.username, peers.name
+		FROM peers
+		INNER JOIN users ON users.id = peers.user_id
+		ORDER BY users.username, peers.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type peerKey struct {
+		username string
+		peername string
+	}
+
+	keys := []peerKey{}
+	for rows.Next() {
+		var key peerKey
+		if err := rows.Scan(&key.username, &key.peername); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	peerConfigs := []PeerConfig{}
+	for _, key := range keys {
+		config := s.GetPeer(key.username, key.peername)
+		if config != nil {
+			peerConfigs = append(peerConfigs, *config)
+		}
+	}
+
+	return peerConfigs, nil
+}
+
+func (s *ServiceDB) DeletePeer(username, peername string) error {
+	err := s.DeleteSession(username, peername)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(
+		`DELETE FROM peers
+		WHERE user_id = (SELECT id FROM users WHERE username = ?)
+		AND name = ?`,
+		username,
+		peername,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *ServiceDB) ModifyPeer(username, peername string, peer wireconnect.ModifyPeerRequest) error {
+	current := s.GetPeer(username, peername)
+	if current == nil {
+		return sql.ErrNoRows
+	}
+
+	peerAddrs := current.Addresses
+	if peer.Address != "" {
+		addrs, err := parsePeerAddresses(peer.Address)
+		if err != nil {
+			return err
+		}
+		peerAddrs = addrs
+	}
+
+	iface := current.DBIface
+	if peer.ServerInterface != "" {
+		newIface, err := s.Interface(peer.ServerInterface)
+		if err != nil {
+			return err
+		}
+		iface = newIface
+	}
+
+	if peer.Address != "" || peer.ServerInterface != "" {
+		for _, addr := range peerAddrs {
+			err := s.checkPeerAddress(iface, addr.Address, username, peername)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	addr6, mask6 := secondaryAddress(peerAddrs)
+
+	endpointHost := current.EndpointAddress
+	if peer.EndpointAddress != "" {
+		endpointHost = net.ParseIP(peer.EndpointAddress)
+		if endpointHost == nil {
+			return errors.New("Invalid endpoint host address")
+		}
+	}
+
+	_, err := s.db.Exec(
+		`UPDATE peers
+		SET address = ?,
+			mask = ?,
+			address6 = ?,
+			mask6 = ?,
+			endpoint_address = ?,
+			server_interface_id = (SELECT id FROM server_interfaces WHERE name = ?)
+		WHERE user_id = (SELECT id FROM users WHERE username = ?)
+		AND name = ?`,
+		peerAddrs[0].Address,
+		peerAddrs[0].Mask,
+		addr6,
+		mask6,
+		endpointHost,
+		iface.Name,
+		username,
+		peername,
+	)
+
+	return addressConflict(err)
+}
+
+// parsePeerAddresses parses a comma-separated list of at most one IPv4 and one IPv6 address
+func parsePeerAddresses(s string) ([]wireconnect.Address, error) {
+	addrs, err := CidrList(s)
+	if err != nil {
+		return nil, ErrInvalidAddress
+	}
+
+	var v4Count, v6Count int
+	for _, addr := range addrs {
+		if addr.Address.To4() != nil {
+			v4Count++
+		} else {
+			v6Count++
+		}
+	}
+
+	if v4Count > 1 || v6Count > 1 {
+		return nil, ErrTooManyAddresses
+	}
+
+	v4, v6 := splitFamilies(addrs)
+	return joinFamilies(v4, v6), nil
+}
+
+// splitFamilies returns the IPv4 and IPv6 addresses in addrs, if present
+func splitFamilies(addrs []wireconnect.Address) (v4, v6 *wireconnect.Address) {
+	for i := range addrs {
+		if addrs[i].Address.To4() != nil {
+			v4 = &addrs[i]
+		} else {
+			v6 = &addrs[i]
+		}
+	}
+
+	return v4, v6
+}
+
+// joinFamilies returns the non-nil addresses, IPv4 first
+func joinFamilies(v4, v6 *wireconnect.Address) []wireconnect.Address {
+	addrs := []wireconnect.Address{}
+	if v4 != nil {
+		addrs = append(addrs, *v4)
+	}
+	if v6 != nil {
+		addrs = append(addrs, *v6)
+	}
+
+	return addrs
+}
+
+// secondaryAddress returns the values stored in the address6 and mask6 columns.
+// The first address is stored in the address and mask columns; the second, if
+// present, is always IPv6.
+func secondaryAddress(addrs []wireconnect.Address) (interface{}, interface{}) {
+	if len(addrs) < 2 {
+		return nil, nil
+	}
+
+	return addrs[1].Address, addrs[1].Mask
+}
+
+// AddressList formats addresses as a comma-separated list in CIDR notation
+func AddressList(addrs []wireconnect.Address) string {
+	strs := []string{}
+	for _, addr := range addrs {
+		strs = append(strs, addr.String())
+	}
+
+	return strings.Join(strs, ",")
+}
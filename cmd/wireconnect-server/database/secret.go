@@ -0,0 +1,110 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Prefix of private keys that are stored encrypted
+const sealedPrefix = "secretbox:"
+
+// LoadSecretKey reads a base64-encoded 32-byte key, such as one created by `wg genkey`
+func LoadSecretKey(path string) (*[32]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) != 32 {
+		return nil, errors.New("Secret key must be 32 bytes long")
+	}
+
+	var key [32]byte
+	copy(key[:], decoded)
+	return &key, nil
+}
+
+// SetSecretKey sets the key used to encrypt interface private keys.
+// If no key is set, private keys are stored unencrypted.
+func (s *ServiceDB) SetSecretKey(key *[32]byte) {
+	s.secretKey = key
+}
+
+func (s *ServiceDB) sealKey(key wgtypes.Key) (string, error) {
+	if s.secretKey == nil {
+		return key.String(), nil
+	}
+
+	return s.seal(key[:])
+}
+
+func (s *ServiceDB) openKey(value string) (*wgtypes.Key, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		key, err := wgtypes.ParseKey(value)
+		if err != nil {
+			return nil, err
+		}
+		return &key, nil
+	}
+
+	opened, err := s.open(value)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := wgtypes.NewKey(opened)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// seal encrypts data with the secret key, which must be set
+func (s *ServiceDB) seal(data []byte) (string, error) {
+	var nonce [24]byte
+	_, err := rand.Read(nonce[:])
+	if err != nil {
+		return "", err
+	}
+
+	sealed := secretbox.Seal(nonce[:], data, &nonce, s.secretKey)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a value created by seal
+func (s *ServiceDB) open(value string) ([]byte, error) {
+	if s.secretKey == nil {
+		return nil, errors.New("Value is encrypted but no secret key was provided")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < 24 {
+		return nil, errors.New("Encrypted value is too short")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+
+	opened, ok := secretbox.Open(nil, sealed[24:], &nonce, s.secretKey)
+	if !ok {
+		return nil, errors.New("Failed to decrypt value")
+	}
+
+	return opened, nil
+}
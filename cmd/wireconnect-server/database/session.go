@@ -0,0 +1,96 @@
+package database
+
+import (
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Session is a peer that is connected to one of the server's interfaces
+type Session struct {
+	Username      string
+	PeerName      string
+	PublicKey     wgtypes.Key
+	ConnectedAt   time.Time
+	SourceAddress string
+}
+
+// SaveSession records a session, replacing any previous session of the same peer
+func (s *ServiceDB) SaveSession(session Session) error {
+	_, err := s.db.Exec(
+		`INSERT OR REPLACE INTO sessions (peer_id, public_key, connected_at, source_address)
+		VALUES (
+			(SELECT peers.id
+				FROM peers
+				INNER JOIN users ON users.id = peers.user_id
+				WHERE users.username = ? AND peers.name = ?),
+			?,
+			?,
+			?
+		)`,
+		session.Username,
+		session.PeerName,
+		session.PublicKey.String(),
+		session.ConnectedAt.Unix(),
+		session.SourceAddress,
+	)
+
+	return err
+}
+
+func (s *ServiceDB) DeleteSession(username, peername string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM sessions
+		WHERE peer_id = (SELECT peers.id
+			FROM peers
+			INNER JOIN users ON users.id = peers.user_id
+			WHERE users.username = ? AND peers.name = ?)`,
+		username,
+		peername,
+	)
+
+	return err
+}
+
+func (s *ServiceDB) Sessions() ([]Session, error) {
+	rows, err := s.db.Query(
+		`SELECT users.username, peers.name, sessions.public_key, sessions.connected_at, sessions.source_address
+		FROM sessions
+		INNER JOIN peers ON peers.id = sessions.peer_id
+		INNER JOIN users ON users.id = peers.user_id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var (
+			session     Session
+			pubkey      string
+			connectedAt int64
+		)
+
+		err := rows.Scan(&session.Username, &session.PeerName, &pubkey, &connectedAt, &session.SourceAddress)
+		if err != nil {
+			return nil, err
+		}
+
+		session.PublicKey, err = wgtypes.ParseKey(pubkey)
+		if err != nil {
+			return nil, err
+		}
+		session.ConnectedAt = time.Unix(connectedAt, 0)
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// FlushSessions deletes all recorded sessions
+func (s *ServiceDB) FlushSessions() error {
+	_, err := s.db.Exec(`DELETE FROM sessions`)
+	return err
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"strings"
+	"time"
+)
+
+// Token is a login session. The access and refresh tokens given to the client
+// are signed by the server and refer to the session by its ID.
+type Token struct {
+	ID            string
+	Username      string
+	IssuedAt      time.Time
+	AccessExpiry  time.Time
+	RefreshExpiry time.Time
+}
+
+// SigningKey returns the key used to sign tokens, creating it if it does not exist.
+// The key is encrypted if a secret key has been set.
+func (s *ServiceDB) SigningKey() ([]byte, error) {
+	var value string
+
+	row := s.db.QueryRow(`SELECT key FROM signing_keys WHERE name = 'tokens'`)
+	switch err := row.Scan(&value); err {
+	case nil:
+		if strings.HasPrefix(value, sealedPrefix) {
+			return s.open(value)
+		}
+		return base64.StdEncoding.DecodeString(value)
+	case sql.ErrNoRows:
+	default:
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value = base64.StdEncoding.EncodeToString(key)
+	if s.secretKey != nil {
+		value, err = s.seal(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = s.db.Exec(`INSERT INTO signing_keys (name, key) VALUES ('tokens', ?)`, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (s *ServiceDB) AddToken(token Token) error {
+	_, err := s.db.Exec(
+		`INSERT INTO tokens (id, user_id, issued_at, access_expiry, refresh_expiry)
+		VALUES (?, (SELECT id FROM users WHERE username = ?), ?, ?, ?)`,
+		token.ID,
+		token.Username,
+		token.IssuedAt.Unix(),
+		token.AccessExpiry.Unix(),
+		token.RefreshExpiry.Unix(),
+	)
+
+	return err
+}
+
+func (s *ServiceDB) GetToken(id string) (*Token, error) {
+	row := s.db.QueryRow(
+		`SELECT tokens.id, users.username, tokens.issued_at, tokens.access_expiry, tokens.refresh_expiry
+		FROM tokens
+		INNER JOIN users ON users.id = tokens.user_id
+		WHERE tokens.id = ?`,
+		id,
+	)
+
+	return scanToken(row)
+}
+
+// ListTokens returns the tokens of username, or of every user if username is empty
+func (s *ServiceDB) ListTokens(username string) ([]Token, error) {
+	rows, err := s.db.Query(
+		`SELECT tokens.id, users.username, tokens.issued_at, tokens.access_expiry, tokens.refresh_expiry
+		FROM tokens
+		INNER JOIN users ON users.id = tokens.user_id
+		WHERE ? = '' OR users.username = ?
+		ORDER BY users.username, tokens.issued_at`,
+		username,
+		username,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []Token{}
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// SetAccessExpiry records the expiry of the most recently issued access token
+func (s *ServiceDB) SetAccessExpiry(id string, expiry time.Time) error {
+	_, err := s.db.Exec(`UPDATE tokens SET access_expiry = ? WHERE id = ?`, expiry.Unix(), id)
+	return err
+}
+
+func (s *ServiceDB) DeleteToken(id string) error {
+	result, err := s.db.Exec(`DELETE FROM tokens WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *ServiceDB) DeleteUserTokens(username string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM tokens WHERE user_id = (SELECT id FROM users WHERE username = ?)`,
+		username,
+	)
+
+	return err
+}
+
+// PurgeExpiredTokens deletes tokens that can no longer be refreshed
+func (s *ServiceDB) PurgeExpiredTokens(now time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM tokens WHERE refresh_expiry <= ?`, now.Unix())
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanToken(row scanner) (*Token, error) {
+	var (
+		token                         Token
+		issued, accessExp, refreshExp int64
+	)
+
+	err := row.Scan(&token.ID, &token.Username, &issued, &accessExp, &refreshExp)
+	if err != nil {
+		return nil, err
+	}
+
+	token.IssuedAt = time.Unix(issued, 0)
+	token.AccessExpiry = time.Unix(accessExp, 0)
+	token.RefreshExpiry = time.Unix(refreshExp, 0)
+
+	return &token, nil
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// sealString encrypts value if a secret key has been set
+func (s *ServiceDB) sealString(value string) (string, error) {
+	if s.secretKey == nil {
+		return value, nil
+	}
+
+	return s.seal([]byte(value))
+}
+
+// openString reverses sealString
+func (s *ServiceDB) openString(value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+
+	opened, err := s.open(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(opened), nil
+}
+
+// SetPendingTOTP stores a TOTP secret that has not yet been confirmed with a code
+func (s *ServiceDB) SetPendingTOTP(username, secret string) error {
+	sealed, err := s.sealString(secret)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(`UPDATE users SET totp_pending_secret = ? WHERE username = ?`, sealed, username)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// PendingTOTP returns the user's unconfirmed TOTP secret, or an empty string if there is none
+func (s *ServiceDB) PendingTOTP(username string) (string, error) {
+	var secret sql.NullString
+
+	row := s.db.QueryRow(`SELECT totp_pending_secret FROM users WHERE username = ?`, username)
+	err := row.Scan(&secret)
+	if err != nil {
+		return "", err
+	}
+
+	return s.openString(secret.String)
+}
+
+// TOTPSecret returns the user's TOTP secret and the last time step that was used,
+// or an empty secret if the user has not enabled TOTP
+func (s *ServiceDB) TOTPSecret(username string) (string, int64, error) {
+	var (
+		secret   sql.NullString
+		lastStep int64
+	)
+
+	row := s.db.QueryRow(`SELECT totp_secret, totp_last_step FROM users WHERE username = ?`, username)
+	err := row.Scan(&secret, &lastStep)
+	if err != nil {
+		return "", 0, err
+	}
+
+	opened, err := s.openString(secret.String)
+	return opened, lastStep, err
+}
+
+// UseTOTPStep records the time step of an accepted code, so that it cannot be reused.
+// sql.ErrNoRows is returned if a code for this or a later step has already been used.
+func (s *ServiceDB) UseTOTPStep(username string, step int64) error {
+	result, err := s.db.Exec(
+		`UPDATE users SET totp_last_step = ? WHERE username = ? AND totp_last_step < ?`,
+		step,
+		username,
+		step,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ActivateTOTP makes the pending secret the user's TOTP secret and replaces the
+// user's recovery codes with the given hashes. step is the time step of the code
+// that confirmed the secret.
+func (s *ServiceDB) ActivateTOTP(username string, recoveryHashes []string, step int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE users
+		SET totp_secret = totp_pending_secret,
+			totp_pending_secret = NULL,
+			totp_last_step = ?
+		WHERE username = ? AND totp_pending_secret IS NOT NULL`,
+		step,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM recovery_codes WHERE user_id = (SELECT id FROM users WHERE username = ?)`,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryHashes {
+		_, err = tx.Exec(
+			`INSERT INTO recovery_codes (user_id, hash) VALUES ((SELECT id FROM users WHERE username = ?), ?)`,
+			username,
+			hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode deletes the user's recovery code with the given hash.
+// sql.ErrNoRows is returned if there is no such code.
+func (s *ServiceDB) UseRecoveryCode(username, hash string) error {
+	result, err := s.db.Exec(
+		`DELETE FROM recovery_codes
+		WHERE user_id = (SELECT id FROM users WHERE username = ?)
+		AND hash = ?`,
+		username,
+		hash,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DisableTOTP removes the user's TOTP secrets and recovery codes
+func (s *ServiceDB) DisableTOTP(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE users SET totp_secret = NULL, totp_pending_secret = NULL, totp_last_step = 0 WHERE username = ?`,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM recovery_codes WHERE user_id = (SELECT id FROM users WHERE username = ?)`,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -2,15 +2,17 @@ package database
 
 import (
 	"database/sql"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	Username    string
-	Password    []byte
-	PeerConfigs []PeerConfig
-	IsAdmin     bool
+	Username           string
+	Password           []byte
+	PeerConfigs        []PeerConfig
+	IsAdmin            bool
+	MaxSessionLifetime time.Duration // 0 means unlimited
 }
 
 func (s *ServiceDB) Authenticate(username, password string) error {
@@ -57,6 +59,25 @@ func (s *ServiceDB) AddUser(user User) error {
 	return err
 }
 
+// ProvisionUser creates the local row of a user who was authenticated by an external
+// backend, if it does not exist yet. The row's password can never match.
+func (s *ServiceDB) ProvisionUser(username string) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO users (username, password) VALUES (?, '!')`, username)
+	return err
+}
+
+func (s *ServiceDB) UserExists(username string) (bool, error) {
+	var count int
+
+	row := s.db.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ?`, username)
+	err := row.Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
 func (s *ServiceDB) UserCount() (uint, error) {
 	var count uint
 
@@ -68,3 +89,163 @@ func (s *ServiceDB) UserCount() (uint, error) {
 		return 0, err
 	}
 }
+
+func (s *ServiceDB) ListUsers() ([]User, error) {
+	rows, err := s.db.Query(`SELECT username, is_admin, max_session_lifetime FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user := User{}
+		var maxLifetime int64
+		if err := rows.Scan(&user.Username, &user.IsAdmin, &maxLifetime); err != nil {
+			return nil, err
+		}
+		user.MaxSessionLifetime = time.Duration(maxLifetime) * time.Second
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// DeleteUser deletes a user along with all of the user's peer configurations, tokens and API keys.
+func (s *ServiceDB) DeleteUser(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`DELETE FROM sessions WHERE peer_id IN
+			(SELECT peers.id FROM peers INNER JOIN users ON users.id = peers.user_id WHERE users.username = ?)`,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM peers WHERE user_id = (SELECT id FROM users WHERE username = ?)`,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM tokens WHERE user_id = (SELECT id FROM users WHERE username = ?)`,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM api_keys WHERE user_id = (SELECT id FROM users WHERE username = ?)`,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM recovery_codes WHERE user_id = (SELECT id FROM users WHERE username = ?)`,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM users WHERE username = ?`, username)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+func (s *ServiceDB) SetAdmin(username string, isAdmin bool) error {
+	result, err := s.db.Exec(`UPDATE users SET is_admin = ? WHERE username = ?`, isAdmin, username)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// MaxSessionLifetime returns how long the user's peers may stay connected, or 0 if unlimited
+func (s *ServiceDB) MaxSessionLifetime(username string) (time.Duration, error) {
+	var seconds int64
+
+	row := s.db.QueryRow(`SELECT max_session_lifetime FROM users WHERE username = ?`, username)
+	err := row.Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func (s *ServiceDB) SetMaxSessionLifetime(username string, lifetime time.Duration) error {
+	result, err := s.db.Exec(
+		`UPDATE users SET max_session_lifetime = ? WHERE username = ?`,
+		int64(lifetime/time.Second),
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetPassword changes a user's password and revokes the user's tokens
+func (s *ServiceDB) SetPassword(username string, password []byte) error {
+	hashedPw, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(`UPDATE users SET password = ? WHERE username = ?`, string(hashedPw), username)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return s.DeleteUserTokens(username)
+}
@@ -11,7 +11,7 @@ func CidrList(s string) ([]wireconnect.Address, error) {
 	addresses := []wireconnect.Address{}
 
 	for _, addr := range strings.Split(s, ",") {
-		ip, net, err := net.ParseCIDR(addr)
+		ip, net, err := net.ParseCIDR(strings.TrimSpace(addr))
 		if err != nil {
 			return nil, err
 		}
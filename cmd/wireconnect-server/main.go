@@ -4,10 +4,12 @@ import (
 	"crypto/tls"
 	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/acmecert"
 	"github.com/sector-f/wireconnect/cmd/wireconnect-server/reloadablecert"
 	"github.com/sector-f/wireconnect/cmd/wireconnect-server/server"
 	flag "github.com/spf13/pflag"
@@ -16,24 +18,56 @@ import (
 func main() {
 	flag.ErrHelp = errors.New("Help requested")
 
-	keyfile := flag.StringP("key", "k", "", "Path to keyfile")
-	certfile := flag.StringP("cert", "c", "", "Path to certfile")
-	dbfile := flag.StringP("database", "d", "file:/var/local/wireconnect.sqlite", "SQLite DSN for wireconnect database")
+	configFile := flag.String("config", "", "Path to TOML configuration file")
+	flags := newCmdFlags(flag.CommandLine)
 	flag.Parse()
 
-	if *keyfile == "" || *certfile == "" {
+	fileConf, err := loadConfig(*configFile, flags)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if fileConf.TLS.ACME == nil && (fileConf.TLS.KeyFile == "" || fileConf.TLS.CertFile == "") {
 		log.Fatalln("Key and cert must be specified")
 	}
 
-	config := server.NewConfig()
-	config.DSN = *dbfile
+	config := fileConf.Config
 	wcServer, err := server.NewServer(config)
 	if err != nil {
-		wcServer.Shutdown()
 		log.Fatal(err)
 	}
 
-	cert, err := reloadablecert.New(*certfile, *keyfile)
+	tlsConfig := &tls.Config{
+		PreferServerCipherSuites: true,
+		MinVersion:               tls.VersionTLS12,
+	}
+
+	// Only set when certificates are loaded from files
+	var cert *reloadablecert.ReloadableCert
+
+	if fileConf.TLS.ACME != nil {
+		manager, err := acmecert.New(*fileConf.TLS.ACME)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = manager.NextProtos()
+
+		err = manager.ServeHTTPChallenges()
+		if err != nil {
+			log.Fatalf("Failed to serve ACME HTTP challenges: %v\n", err)
+		}
+	} else {
+		cert, err = reloadablecert.New(fileConf.TLS.CertFile, fileConf.TLS.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tlsConfig.GetCertificate = cert.GetCertificate
+	}
+
+	err = fileConf.TLS.clientAuthConfig(tlsConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -43,6 +77,11 @@ func main() {
 
 	go func() {
 		for _ = range sigChan {
+			if cert == nil {
+				log.Println("Certificates are managed by ACME; ignoring SIGUSR1")
+				continue
+			}
+
 			err := cert.Reload()
 			if err != nil {
 				log.Printf("Failed to reload TLS key/certificate: %v\n", err)
@@ -52,21 +91,74 @@ func main() {
 		}
 	}()
 
-	tlsConfig := &tls.Config{
-		GetCertificate:           cert.GetCertificate,
-		PreferServerCipherSuites: true,
-		MinVersion:               tls.VersionTLS12,
-	}
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	go func() {
+		for _ = range hupChan {
+			newConf, err := loadConfig(*configFile, flags)
+			if err != nil {
+				log.Printf("Failed to reload configuration: %v\n", err)
+				continue
+			}
+
+			for _, name := range staticChanges(fileConf, newConf) {
+				log.Printf("Setting %s cannot be changed without restarting\n", name)
+			}
+
+			filesChanged := newConf.TLS.CertFile != fileConf.TLS.CertFile || newConf.TLS.KeyFile != fileConf.TLS.KeyFile
+			if cert != nil && filesChanged {
+				err := cert.SetFiles(newConf.TLS.CertFile, newConf.TLS.KeyFile)
+				if err != nil {
+					log.Printf("Failed to load new TLS key/certificate: %v\n", err)
+					newConf.TLS.CertFile = fileConf.TLS.CertFile
+					newConf.TLS.KeyFile = fileConf.TLS.KeyFile
+				}
+			}
+
+			wcServer.Reload(newConf.Config)
+			fileConf = newConf
+			log.Println("Reloaded configuration")
+		}
+	}()
 
 	listener, err := tls.Listen("tcp", config.Address, tlsConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	stopChan := make(chan os.Signal, 1)
+	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGTERM)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		sig := <-stopChan
+
+		switch sig {
+		case syscall.SIGINT:
+			log.Println("Caught SIGINT")
+		case syscall.SIGTERM:
+			log.Println("Caught SIGTERM")
+		default:
+			// Shouldn't occur
+			log.Println("Caught signal")
+		}
+
+		err := wcServer.Stop()
+		if err != nil {
+			log.Printf("Failed to finish in-flight requests: %v\n", err)
+		}
+
+		close(stopped)
+	}()
+
 	log.Printf("Listening on %s\n", config.Address)
 	err = wcServer.Serve(listener)
-	if err != nil {
+	if err != http.ErrServerClosed {
 		wcServer.Shutdown()
 		log.Fatal(err)
 	}
+
+	<-stopped
 }
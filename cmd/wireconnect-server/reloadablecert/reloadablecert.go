@@ -46,3 +46,19 @@ func (cert *ReloadableCert) GetCertificate(clientHello *tls.ClientHelloInfo) (*t
 
 	return cert.tlsCert, nil
 }
+
+// SetFiles loads a key pair from new paths. The paths are used by later calls to Reload.
+func (cert *ReloadableCert) SetFiles(certFile string, keyFile string) error {
+	cert.mu.Lock()
+	defer cert.mu.Unlock()
+
+	newCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert.certFile = certFile
+	cert.keyFile = keyFile
+	cert.tlsCert = &newCert
+	return nil
+}
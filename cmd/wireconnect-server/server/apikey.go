@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
+)
+
+// API keys have the form wck_ID_SECRET
+const apiKeyPrefix = "wck_"
+
+// Scope that limits an API key to GET and HEAD requests
+const scopeRead = "read"
+
+// Scopes that limit an API key to a group of routes
+var routeScopes = []string{"peers", "users", "interfaces", "tokens", "keys", "bans"}
+
+// validScope reports whether scope can be given to an API key
+func validScope(scope string) bool {
+	if scope == scopeRead {
+		return true
+	}
+
+	for _, s := range routeScopes {
+		if scope == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedByScopes reports whether an API key with the given scopes may use method on
+// a route in routeScope. A key without scopes may use every route.
+func allowedByScopes(scopes []string, routeScope, method string) bool {
+	var limitedRoutes, routeAllowed bool
+
+	for _, scope := range scopes {
+		if scope == scopeRead {
+			if method != "GET" && method != "HEAD" {
+				return false
+			}
+			continue
+		}
+
+		limitedRoutes = true
+		if scope == routeScope {
+			routeAllowed = true
+		}
+	}
+
+	return !limitedRoutes || routeAllowed
+}
+
+// newAPIKey generates a key and returns it along with its ID and the hash to store
+func newAPIKey() (key, id, hash string, err error) {
+	idBytes := make([]byte, 8)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	id = hex.EncodeToString(idBytes)
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+
+	return apiKeyPrefix + id + "_" + encoded, id, hashSecret(encoded), nil
+}
+
+// The secret is random, so a fast hash is sufficient
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyAPIKey checks an API key and returns its database entry
+func (s *Server) verifyAPIKey(key string) (*database.APIKey, error) {
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil, errInvalidToken
+	}
+
+	dbKey, err := s.db.GetAPIKey(parts[0])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(parts[1])), []byte(dbKey.Hash)) != 1 {
+		return nil, errInvalidToken
+	}
+
+	return dbKey, nil
+}
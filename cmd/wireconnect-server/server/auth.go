@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
+)
+
+// Authenticator checks the credentials of users. Every backend keeps a row in the
+// local users table for each user, which holds peers, sessions and admin status.
+type Authenticator interface {
+	Authenticate(username, password string) error
+
+	// UserExists is checked for requests made with tokens, API keys or client
+	// certificates, which outlive a user's removal from an external backend
+	UserExists(username string) (bool, error)
+	IsAdmin(username string) (bool, error)
+}
+
+// AuthConfig selects the authentication backend
+type AuthConfig struct {
+	Backend  string         `toml:"backend"` // "database" (default), "htpasswd" or "ldap"
+	Htpasswd HtpasswdConfig `toml:"htpasswd"`
+	LDAP     LDAPConfig     `toml:"ldap"`
+}
+
+// newAuthenticator returns the backend selected by conf
+func newAuthenticator(conf AuthConfig, db *database.ServiceDB) (Authenticator, error) {
+	switch conf.Backend {
+	case "", "database":
+		return db, nil
+	case "htpasswd":
+		return newHtpasswdAuthenticator(conf.Htpasswd, db)
+	case "ldap":
+		return newLDAPAuthenticator(conf.LDAP, db)
+	default:
+		return nil, fmt.Errorf("Unknown authentication backend %s", conf.Backend)
+	}
+}
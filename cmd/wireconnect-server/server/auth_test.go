@@ -0,0 +1,56 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
+)
+
+// errAny is used in test tables where any error is expected
+var errAny = errors.New("any error")
+
+// newTestDB returns a database in a temporary directory
+func newTestDB(t *testing.T) *database.ServiceDB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "wireconnect.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	serviceDB, err := database.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return serviceDB
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	db := newTestDB(t)
+
+	tests := []struct {
+		name    string
+		conf    AuthConfig
+		wantErr bool
+	}{
+		{"default", AuthConfig{}, false},
+		{"database", AuthConfig{Backend: "database"}, false},
+		{"htpasswd without file", AuthConfig{Backend: "htpasswd"}, true},
+		{"ldap without url", AuthConfig{Backend: "ldap"}, true},
+		{"unknown", AuthConfig{Backend: "kerberos"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newAuthenticator(test.conf, db)
+			if (err != nil) != test.wantErr {
+				t.Errorf("newAuthenticator() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
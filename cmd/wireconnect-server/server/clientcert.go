@@ -0,0 +1,56 @@
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// validClientCertUser reports whether field can be used as Config.ClientCertUser
+func validClientCertUser(field string) bool {
+	switch field {
+	case "", "cn", "email", "dns":
+		return true
+	default:
+		return false
+	}
+}
+
+// certUser returns the user that a verified client certificate belongs to.
+// The username is taken from the field selected by Config.ClientCertUser,
+// and the user must exist both in the users table and in the authentication backend.
+func (s *Server) certUser(cert *x509.Certificate) (string, error) {
+	var candidates []string
+
+	switch s.clientCertUser {
+	case "", "cn":
+		candidates = []string{cert.Subject.CommonName}
+	case "email":
+		candidates = cert.EmailAddresses
+	case "dns":
+		candidates = cert.DNSNames
+	}
+
+	for _, username := range candidates {
+		if username == "" {
+			continue
+		}
+
+		exists, err := s.db.UserExists(username)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			continue
+		}
+
+		// External backends may have removed the user since they last logged in
+		err = s.checkUserExists(username)
+		if err != nil {
+			return "", err
+		}
+
+		return username, nil
+	}
+
+	return "", errors.New("Client certificate does not belong to a known user")
+}
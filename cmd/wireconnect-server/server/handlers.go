@@ -1,14 +1,52 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net"
 	"net/http"
 	"strings"
 
 	"github.com/sector-f/wireconnect"
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
 )
 
+// authMode determines which credentials a route accepts
+type authMode int
+
+const (
+	authAny      authMode = iota // Password, access token or API key
+	authPassword                 // Password only
+	authRefresh                  // Refresh token only
+)
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	tokenKey
+	apiKeyKey
+)
+
+// requestUser returns the name of the user that made an authenticated request
+func requestUser(r *http.Request) string {
+	username, _ := r.Context().Value(userKey).(string)
+	return username
+}
+
+// requestAPIKey returns the API key that authenticated the request, if any
+func requestAPIKey(r *http.Request) *database.APIKey {
+	key, _ := r.Context().Value(apiKeyKey).(*database.APIKey)
+	return key
+}
+
+// requestToken returns the session whose token authenticated the request, if any
+func requestToken(r *http.Request) *database.Token {
+	token, _ := r.Context().Value(tokenKey).(*database.Token)
+	return token
+}
+
 type apiFunc = func(*http.Request) (*wireconnect.SuccessResponse, error)
 
 func jsonHandler(internal apiFunc) http.HandlerFunc {
@@ -45,9 +83,9 @@ func jsonHandler(internal apiFunc) http.HandlerFunc {
 
 func (s *Server) adminHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		username, _, _ := r.BasicAuth()
+		username := requestUser(r)
 
-		isAdmin, err := s.db.IsAdmin(username)
+		isAdmin, err := s.auth.IsAdmin(username)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			// TODO: replace with json
@@ -66,38 +104,159 @@ func (s *Server) adminHandler(h http.Handler) http.Handler {
 	})
 }
 
-func (s *Server) authLimit(h http.Handler) http.Handler {
+// sourceAddress returns the IP address that the request came from
+func sourceAddress(r *http.Request) string {
+	if strings.Contains(r.RemoteAddr, ":") {
+		sourceAddr, _, _ := net.SplitHostPort(r.RemoteAddr)
+		return sourceAddr
+	}
+
+	return r.RemoteAddr
+}
+
+// authenticate checks the credentials of a request according to mode and returns a
+// context recording the user along with the token or API key that was used, if any.
+// If needsOTP is set, users with TOTP enabled must also send a one-time code.
+func (s *Server) authenticate(r *http.Request, mode authMode, needsOTP bool) (context.Context, error) {
+	ctx := r.Context()
+
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		bearer := strings.TrimPrefix(header, "Bearer ")
+
+		if strings.HasPrefix(bearer, apiKeyPrefix) {
+			if mode != authAny {
+				return nil, errors.New("API keys cannot be used here")
+			}
+
+			key, err := s.verifyAPIKey(bearer)
+			if err != nil {
+				return nil, err
+			}
+
+			err = s.checkUserExists(key.Username)
+			if err != nil {
+				return nil, err
+			}
+
+			ctx = context.WithValue(ctx, apiKeyKey, key)
+			return context.WithValue(ctx, userKey, key.Username), nil
+		}
+
+		kind := tokenAccess
+		switch mode {
+		case authPassword:
+			return nil, errors.New("Password required")
+		case authRefresh:
+			kind = tokenRefresh
+		}
+
+		token, err := s.verifyToken(bearer, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.checkUserExists(token.Username)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx = context.WithValue(ctx, tokenKey, token)
+		return context.WithValue(ctx, userKey, token.Username), nil
+	}
+
+	if mode == authRefresh {
+		return nil, errors.New("Refresh token required")
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		// The TLS listener has already verified the certificate against the client CA
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			username, err := s.certUser(r.TLS.VerifiedChains[0][0])
+			if err != nil {
+				return nil, err
+			}
+
+			// A certificate replaces the password, not the one-time code
+			if needsOTP {
+				err = s.checkOTP(username, r.Header.Get(wireconnect.OTPHeader))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return context.WithValue(ctx, userKey, username), nil
+		}
+
+		return nil, errors.New("Authentication required")
+	}
+
+	err := s.auth.Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Codes can only be used once, so they are only required where a session starts
+	if needsOTP {
+		err = s.checkOTP(username, r.Header.Get(wireconnect.OTPHeader))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return context.WithValue(ctx, userKey, username), nil
+}
+
+// checkUserExists rejects credentials of users who have been removed from the authentication backend
+func (s *Server) checkUserExists(username string) error {
+	exists, err := s.auth.UserExists(username)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("No such user")
+	}
+
+	return nil
+}
+
+// scopeHandler rejects requests made with an API key whose scopes do not cover routeScope
+func (s *Server) scopeHandler(h http.Handler, routeScope string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var sourceAddr string
-		if strings.Contains(r.RemoteAddr, ":") {
-			sourceAddr, _, _ = net.SplitHostPort(r.RemoteAddr)
-		} else {
-			sourceAddr = r.RemoteAddr
+		key := requestAPIKey(r)
+		if key != nil && !allowedByScopes(key.Scopes, routeScope, r.Method) {
+			w.WriteHeader(http.StatusForbidden)
+			return
 		}
 
-		bucket := limiter.getIP(sourceAddr)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) authLimit(h http.Handler, mode authMode, needsOTP bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sourceAddr := sourceAddress(r)
+
+		bucket := s.limiter.getIP(sourceAddr)
 		if bucket.TakeAvailable(1) == 0 {
 			w.WriteHeader(http.StatusTooManyRequests)
 			// io.WriteString(w, "Rate limit has been reached\n")
 			return
 		}
 
-		username, password, ok := r.BasicAuth()
-		if !ok {
-			w.WriteHeader(http.StatusUnauthorized)
-			// io.WriteString(w, "Authentication required\n")
-			return
-		}
-
-		err := s.db.Authenticate(username, password)
+		ctx, err := s.authenticate(r, mode, needsOTP)
 		if err != nil {
+			if err == errOTPRequired {
+				w.Header().Set(wireconnect.OTPHeader, "required")
+			}
 			w.WriteHeader(http.StatusUnauthorized)
 			// io.WriteString(w, "Bad username or password\n")
 			return
 		}
 
-		limiter.delIP(sourceAddr)
+		s.limiter.delIP(sourceAddr)
 
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
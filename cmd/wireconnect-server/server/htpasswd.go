@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type HtpasswdConfig struct {
+	File string `toml:"file"`
+
+	// Users who are administrators; everyone else is not. If empty, admin status is managed locally.
+	AdminUsers []string `toml:"admin_users"`
+}
+
+// htpasswdAuthenticator checks passwords against an Apache htpasswd file.
+// Only bcrypt and {SHA} hashes are supported. The file is read on every
+// attempt, so changes take effect immediately.
+type htpasswdAuthenticator struct {
+	conf HtpasswdConfig
+	db   *database.ServiceDB
+}
+
+var (
+	errUnsupportedHash = errors.New("Unsupported htpasswd hash; use bcrypt (htpasswd -B)")
+	errHtpasswdNoUser  = errors.New("No such user")
+)
+
+func newHtpasswdAuthenticator(conf HtpasswdConfig, db *database.ServiceDB) (*htpasswdAuthenticator, error) {
+	if conf.File == "" {
+		return nil, errors.New("htpasswd file not specified")
+	}
+
+	_, err := os.Stat(conf.File)
+	if err != nil {
+		return nil, err
+	}
+
+	return &htpasswdAuthenticator{conf: conf, db: db}, nil
+}
+
+func (h *htpasswdAuthenticator) Authenticate(username, password string) error {
+	hash, err := h.lookup(username)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		encoded := base64.StdEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(encoded), []byte(strings.TrimPrefix(hash, "{SHA}"))) != 1 {
+			err = errors.New("Incorrect password")
+		}
+	default:
+		err = errUnsupportedHash
+	}
+	if err != nil {
+		return err
+	}
+
+	err = h.db.ProvisionUser(username)
+	if err != nil {
+		return err
+	}
+
+	if len(h.conf.AdminUsers) == 0 {
+		return nil
+	}
+
+	return h.db.SetAdmin(username, h.inAdminUsers(username))
+}
+
+// UserExists reports whether the user is still in the htpasswd file
+func (h *htpasswdAuthenticator) UserExists(username string) (bool, error) {
+	_, err := h.lookup(username)
+	switch err {
+	case nil:
+		return true, nil
+	case errHtpasswdNoUser:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// IsAdmin reports whether the user is listed in admin_users. If the list is empty,
+// the admin status recorded in the database is used.
+func (h *htpasswdAuthenticator) IsAdmin(username string) (bool, error) {
+	if len(h.conf.AdminUsers) == 0 {
+		return h.db.IsAdmin(username)
+	}
+
+	return h.inAdminUsers(username), nil
+}
+
+func (h *htpasswdAuthenticator) inAdminUsers(username string) bool {
+	for _, admin := range h.conf.AdminUsers {
+		if admin == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookup returns the hash stored for username
+func (h *htpasswdAuthenticator) lookup(username string) (string, error) {
+	file, err := os.Open(h.conf.File)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) == 2 && fields[0] == username {
+			return fields[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", errHtpasswdNoUser
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestHtpasswd writes an htpasswd file with the given username:hash lines
+func newTestHtpasswd(t *testing.T, adminUsers []string, lines ...string) *htpasswdAuthenticator {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := newHtpasswdAuthenticator(HtpasswdConfig{File: path, AdminUsers: adminUsers}, newTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func TestHtpasswdAuthenticate(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha1.Sum([]byte("sha-pass"))
+	shaHash := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+
+	lines := []string{
+		"# comment",
+		"alice:" + string(bcryptHash),
+		"bob:" + shaHash,
+		"carol:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/", // MD5 (htpasswd -m)
+		"dave:plaintext",
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error // nil for success; errAny for any error
+	}{
+		{"bcrypt", "alice", "bcrypt-pass", nil},
+		{"bcrypt wrong password", "alice", "wrong", errAny},
+		{"sha", "bob", "sha-pass", nil},
+		{"sha wrong password", "bob", "wrong", errAny},
+		{"md5 unsupported", "carol", "anything", errUnsupportedHash},
+		{"plaintext unsupported", "dave", "plaintext", errUnsupportedHash},
+		{"unknown user", "mallory", "bcrypt-pass", errHtpasswdNoUser},
+		{"comment is not a user", "# comment", "", errHtpasswdNoUser},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newTestHtpasswd(t, nil, lines...)
+
+			err := h.Authenticate(test.username, test.password)
+			switch {
+			case test.wantErr == nil && err != nil:
+				t.Fatalf("Authenticate() = %v, want success", err)
+			case test.wantErr == errAny && err == nil:
+				t.Fatal("Authenticate() succeeded, want error")
+			case test.wantErr != nil && test.wantErr != errAny && err != test.wantErr:
+				t.Fatalf("Authenticate() = %v, want %v", err, test.wantErr)
+			}
+
+			// Users are only provisioned once they have authenticated
+			exists, err := h.db.UserExists(test.username)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != (test.wantErr == nil) {
+				t.Errorf("user provisioned = %v, want %v", exists, test.wantErr == nil)
+			}
+		})
+	}
+}
+
+func TestHtpasswdAdminUsers(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newTestHtpasswd(t, []string{"alice"}, "alice:"+string(hash), "bob:"+string(hash))
+
+	// bob was made an administrator locally, but is not in admin_users
+	err = h.db.ProvisionUser("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.db.SetAdmin("bob", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for username, want := range map[string]bool{"alice": true, "bob": false} {
+		err = h.Authenticate(username, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		isAdmin, err := h.db.IsAdmin(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isAdmin != want {
+			t.Errorf("%s: stored admin status = %v, want %v", username, isAdmin, want)
+		}
+
+		isAdmin, err = h.IsAdmin(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isAdmin != want {
+			t.Errorf("%s: IsAdmin() = %v, want %v", username, isAdmin, want)
+		}
+	}
+}
+
+func TestHtpasswdUserExists(t *testing.T) {
+	h := newTestHtpasswd(t, nil, "alice:{SHA}x")
+
+	for username, want := range map[string]bool{"alice": true, "bob": false} {
+		exists, err := h.UserExists(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != want {
+			t.Errorf("UserExists(%s) = %v, want %v", username, exists, want)
+		}
+	}
+
+	// Removing a user from the file takes effect immediately
+	err := ioutil.WriteFile(h.conf.File, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := h.UserExists("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("UserExists(alice) = true after removal from htpasswd file")
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// scheduleKeyRotation arranges for the interface's pending private key, if any,
+// to replace its current key once the rotation time has been reached.
+func (s *Server) scheduleKeyRotation(iface *database.DBIface) {
+	if iface.NextPrivateKey == nil {
+		return
+	}
+
+	name := iface.Name
+	time.AfterFunc(time.Until(iface.KeyRotationTime), func() {
+		err := s.rotateKey(name)
+		if err != nil {
+			log.Printf("Failed to rotate private key of interface %s: %v\n", name, err)
+		}
+	})
+}
+
+// rotateKey replaces the interface's private key with its pending key.
+// Peers connected to the interface are removed, since they can no longer complete a handshake.
+func (s *Server) rotateKey(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	iface, err := s.db.Interface(name)
+	if err != nil {
+		return err
+	}
+
+	// The rotation may have been cancelled or rescheduled in the meantime
+	if iface.NextPrivateKey == nil || time.Now().Before(iface.KeyRotationTime) {
+		return nil
+	}
+
+	newKey := *iface.NextPrivateKey
+
+	_, link := s.activeIface(name)
+	if link != nil {
+		for username, peers := range s.activePeers {
+			for peername := range peers {
+				peerConfig := s.db.GetPeer(username, peername)
+				if peerConfig == nil || peerConfig.DBIface.Name != name {
+					continue
+				}
+
+				err = s.removePeer(username, peername)
+				if err != nil {
+					log.Printf("Failed to remove peer %s/%s: %v\n", username, peername, err)
+				}
+			}
+		}
+
+		err = s.wgClient.ConfigureDevice(name, wgtypes.Config{PrivateKey: &newKey})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.db.SetIfacePrivateKey(name, newKey)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Rotated private key of interface %s; new public key is %s\n", name, newKey.PublicKey())
+	return nil
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
+)
+
+const (
+	// Limit on connecting to the directory and on each request, so that a dead
+	// server does not hold authentication requests open
+	ldapTimeout = 10 * time.Second
+
+	// How long the directory's answer about a user is reused when checking
+	// requests made with tokens, API keys or certificates
+	ldapCacheTTL = time.Minute
+)
+
+var (
+	errLDAPUserNotFound  = errors.New("User not found")
+	errLDAPUserNotUnique = errors.New("User is not unique")
+)
+
+type LDAPConfig struct {
+	URL                string `toml:"url"` // ldap:// or ldaps://
+	StartTLS           bool   `toml:"start_tls"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+
+	// Account used to search for users and groups; anonymous if empty
+	BindDN       string `toml:"bind_dn"`
+	BindPassword string `toml:"bind_password"`
+
+	UserBaseDN string `toml:"user_base_dn"`
+	UserFilter string `toml:"user_filter"` // %s is replaced by the username, e.g. (uid=%s)
+
+	// Members of this group are administrators. If empty, admin status is managed locally.
+	AdminGroupDN string `toml:"admin_group_dn"`
+	GroupFilter  string `toml:"group_filter"` // %s is replaced by the user's DN; Default: (member=%s)
+}
+
+// ldapConn is the part of *ldap.Conn used by ldapAuthenticator.
+// Tests can substitute an in-process implementation by replacing dial.
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	StartTLS(config *tls.Config) error
+}
+
+// ldapUser is what the directory says about a user
+type ldapUser struct {
+	exists  bool
+	isAdmin bool
+	expires time.Time
+}
+
+// ldapAuthenticator authenticates users by binding to an LDAP directory as them.
+// Users are provisioned locally on their first login, and their admin status is
+// synchronized with the admin group on every login. Requests that do not carry
+// a password are checked against the directory as well, with a short cache.
+type ldapAuthenticator struct {
+	conf      LDAPConfig
+	db        *database.ServiceDB
+	tlsConfig *tls.Config
+	dial      func(url string) (conn ldapConn, close func(), err error)
+
+	mu    sync.Mutex
+	users map[string]ldapUser
+}
+
+func newLDAPAuthenticator(conf LDAPConfig, db *database.ServiceDB) (*ldapAuthenticator, error) {
+	if conf.URL == "" || conf.UserBaseDN == "" || conf.UserFilter == "" {
+		return nil, errors.New("LDAP url, user_base_dn and user_filter must be specified")
+	}
+
+	u, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid LDAP url: %v", err)
+	}
+
+	if conf.GroupFilter == "" {
+		conf.GroupFilter = "(member=%s)"
+	}
+
+	l := &ldapAuthenticator{
+		conf: conf,
+		db:   db,
+		// StartTLS does not know the server's name, so it has to be set here
+		tlsConfig: &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: conf.InsecureSkipVerify},
+		users:     make(map[string]ldapUser),
+	}
+	l.dial = l.dialLDAP
+
+	return l, nil
+}
+
+func (l *ldapAuthenticator) dialLDAP(addr string) (ldapConn, func(), error) {
+	conn, err := ldap.DialURL(
+		addr,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(l.tlsConfig),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	return conn, func() { conn.Close() }, nil
+}
+
+// connect dials the directory and binds as the service account
+func (l *ldapAuthenticator) connect() (ldapConn, func(), error) {
+	conn, closeConn, err := l.dial(l.conf.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if l.conf.StartTLS {
+		err = conn.StartTLS(l.tlsConfig)
+		if err != nil {
+			closeConn()
+			return nil, nil, err
+		}
+	}
+
+	err = l.serviceBind(conn)
+	if err != nil {
+		closeConn()
+		return nil, nil, err
+	}
+
+	return conn, closeConn, nil
+}
+
+func (l *ldapAuthenticator) Authenticate(username, password string) error {
+	// Most servers treat a bind with an empty password as an anonymous bind, which succeeds
+	if password == "" {
+		return errors.New("Empty password")
+	}
+
+	conn, closeConn, err := l.connect()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	userDN, err := l.findUser(conn, username)
+	if err != nil {
+		return err
+	}
+
+	err = conn.Bind(userDN, password)
+	if err != nil {
+		return err
+	}
+
+	err = l.db.ProvisionUser(username)
+	if err != nil {
+		return err
+	}
+
+	if l.conf.AdminGroupDN == "" {
+		l.remember(username, ldapUser{exists: true})
+		return nil
+	}
+
+	// The user may not be allowed to read group membership
+	err = l.serviceBind(conn)
+	if err != nil {
+		return err
+	}
+
+	isAdmin, err := l.inAdminGroup(conn, userDN)
+	if err != nil {
+		return err
+	}
+
+	err = l.db.SetAdmin(username, isAdmin)
+	if err != nil {
+		return err
+	}
+
+	l.remember(username, ldapUser{exists: true, isAdmin: isAdmin})
+	return nil
+}
+
+// UserExists reports whether the user is still in the directory
+func (l *ldapAuthenticator) UserExists(username string) (bool, error) {
+	user, err := l.lookup(username)
+	return user.exists, err
+}
+
+// IsAdmin reports whether the user is a member of the admin group. If no admin group
+// is configured, the admin status recorded in the database is used.
+func (l *ldapAuthenticator) IsAdmin(username string) (bool, error) {
+	if l.conf.AdminGroupDN == "" {
+		return l.db.IsAdmin(username)
+	}
+
+	user, err := l.lookup(username)
+	return user.isAdmin, err
+}
+
+// lookup returns what the directory says about a user, using the cache if possible
+func (l *ldapAuthenticator) lookup(username string) (ldapUser, error) {
+	l.mu.Lock()
+	user, cached := l.users[username]
+	l.mu.Unlock()
+
+	if cached && time.Now().Before(user.expires) {
+		return user, nil
+	}
+
+	conn, closeConn, err := l.connect()
+	if err != nil {
+		return ldapUser{}, err
+	}
+	defer closeConn()
+
+	userDN, err := l.findUser(conn, username)
+	switch err {
+	case nil:
+		user = ldapUser{exists: true}
+	case errLDAPUserNotFound:
+		l.remember(username, ldapUser{})
+		return ldapUser{}, nil
+	default:
+		return ldapUser{}, err
+	}
+
+	if l.conf.AdminGroupDN != "" {
+		user.isAdmin, err = l.inAdminGroup(conn, userDN)
+		if err != nil {
+			return ldapUser{}, err
+		}
+
+		err = l.db.SetAdmin(username, user.isAdmin)
+		if err != nil {
+			return ldapUser{}, err
+		}
+	}
+
+	l.remember(username, user)
+	return user, nil
+}
+
+func (l *ldapAuthenticator) remember(username string, user ldapUser) {
+	user.expires = time.Now().Add(ldapCacheTTL)
+
+	l.mu.Lock()
+	l.users[username] = user
+	l.mu.Unlock()
+}
+
+func (l *ldapAuthenticator) serviceBind(conn ldapConn) error {
+	if l.conf.BindDN == "" {
+		return nil
+	}
+
+	return conn.Bind(l.conf.BindDN, l.conf.BindPassword)
+}
+
+// findUser returns the DN of the only entry matching the user filter
+func (l *ldapAuthenticator) findUser(conn ldapConn, username string) (string, error) {
+	request := ldap.NewSearchRequest(
+		l.conf.UserBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		0,
+		false,
+		fmt.Sprintf(l.conf.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return "", errLDAPUserNotFound
+	case 1:
+		return result.Entries[0].DN, nil
+	default:
+		return "", errLDAPUserNotUnique
+	}
+}
+
+func (l *ldapAuthenticator) inAdminGroup(conn ldapConn, userDN string) (bool, error) {
+	request := ldap.NewSearchRequest(
+		l.conf.AdminGroupDN,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		1,
+		0,
+		false,
+		fmt.Sprintf(l.conf.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{"dn"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return len(result.Entries) > 0, nil
+}
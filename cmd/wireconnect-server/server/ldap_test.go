@@ -0,0 +1,284 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeDirectory is an in-process ldapConn. It only understands the filters
+// used by the tests: (uid=...) for users and (member=...) for groups.
+type fakeDirectory struct {
+	passwords map[string]string // DN -> password
+	users     map[string][]string
+	admins    map[string]bool // Members of the admin group, by DN
+
+	binds   []string // DNs bound as, in order
+	filters []string // Filters searched for, in order
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		passwords: map[string]string{"cn=service,dc=example,dc=com": "service-pass"},
+		users:     make(map[string][]string),
+		admins:    make(map[string]bool),
+	}
+}
+
+// addUser adds an entry for uid below ou=people
+func (d *fakeDirectory) addUser(uid, password string) string {
+	dn := fmt.Sprintf("uid=%s,ou=people,dc=example,dc=com", uid)
+	d.passwords[dn] = password
+	d.users[ldap.EscapeFilter(uid)] = append(d.users[ldap.EscapeFilter(uid)], dn)
+	return dn
+}
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	d.binds = append(d.binds, username)
+
+	if want, ok := d.passwords[username]; !ok || password == "" || want != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+
+	return nil
+}
+
+func (d *fakeDirectory) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.filters = append(d.filters, request.Filter)
+
+	result := &ldap.SearchResult{}
+	switch {
+	case strings.HasPrefix(request.Filter, "(uid="):
+		uid := strings.TrimSuffix(strings.TrimPrefix(request.Filter, "(uid="), ")")
+		for _, dn := range d.users[uid] {
+			result.Entries = append(result.Entries, &ldap.Entry{DN: dn})
+		}
+	case strings.HasPrefix(request.Filter, "(member="):
+		if request.BaseDN != "cn=admins,ou=groups,dc=example,dc=com" {
+			return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object"))
+		}
+
+		for dn := range d.admins {
+			if request.Filter == fmt.Sprintf("(member=%s)", ldap.EscapeFilter(dn)) {
+				result.Entries = append(result.Entries, &ldap.Entry{DN: request.BaseDN})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected filter %s", request.Filter)
+	}
+
+	return result, nil
+}
+
+func (d *fakeDirectory) StartTLS(config *tls.Config) error {
+	return nil
+}
+
+func newTestLDAP(t *testing.T, d *fakeDirectory, adminGroupDN string) *ldapAuthenticator {
+	t.Helper()
+
+	l, err := newLDAPAuthenticator(LDAPConfig{
+		URL:          "ldap://ldap.example.com",
+		BindDN:       "cn=service,dc=example,dc=com",
+		BindPassword: "service-pass",
+		UserBaseDN:   "ou=people,dc=example,dc=com",
+		UserFilter:   "(uid=%s)",
+		AdminGroupDN: adminGroupDN,
+	}, newTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.dial = func(url string) (ldapConn, func(), error) {
+		return d, func() {}, nil
+	}
+
+	return l
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	d := newFakeDirectory()
+	d.addUser("alice", "alice-pass")
+	d.addUser("bob", "bob-pass")
+	d.addUser("bob", "other-pass")
+	d.addUser("*)(uid=*", "star-pass")
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		wantErr    error // nil for success; errAny for any error
+		wantFilter string
+	}{
+		{"success", "alice", "alice-pass", nil, "(uid=alice)"},
+		{"wrong password", "alice", "wrong", errAny, "(uid=alice)"},
+		{"unknown user", "mallory", "alice-pass", errLDAPUserNotFound, "(uid=mallory)"},
+		{"not unique", "bob", "bob-pass", errLDAPUserNotUnique, "(uid=bob)"},
+		{"filter is escaped", "*)(uid=*", "star-pass", nil, `(uid=\2a\29\28uid=\2a)`},
+		{"escaped wildcard does not match others", "*", "alice-pass", errLDAPUserNotFound, `(uid=\2a)`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLDAP(t, d, "")
+			d.filters = nil
+
+			err := l.Authenticate(test.username, test.password)
+			switch {
+			case test.wantErr == nil && err != nil:
+				t.Fatalf("Authenticate() = %v, want success", err)
+			case test.wantErr == errAny && err == nil:
+				t.Fatal("Authenticate() succeeded, want error")
+			case test.wantErr != nil && test.wantErr != errAny && err != test.wantErr:
+				t.Fatalf("Authenticate() = %v, want %v", err, test.wantErr)
+			}
+
+			if len(d.filters) == 0 || d.filters[0] != test.wantFilter {
+				t.Errorf("searched for %q, want %q", d.filters, test.wantFilter)
+			}
+
+			exists, err := l.db.UserExists(test.username)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != (test.wantErr == nil) {
+				t.Errorf("user provisioned = %v, want %v", exists, test.wantErr == nil)
+			}
+		})
+	}
+}
+
+func TestLDAPEmptyPassword(t *testing.T) {
+	d := newFakeDirectory()
+	d.addUser("alice", "alice-pass")
+	l := newTestLDAP(t, d, "")
+
+	err := l.Authenticate("alice", "")
+	if err == nil {
+		t.Fatal("Authenticate() with an empty password succeeded")
+	}
+
+	// An empty password must not even reach the directory, where it would be an anonymous bind
+	if len(d.binds) != 0 {
+		t.Errorf("bound as %v, want no binds", d.binds)
+	}
+}
+
+func TestLDAPAdminGroup(t *testing.T) {
+	d := newFakeDirectory()
+	aliceDN := d.addUser("alice", "alice-pass")
+	d.addUser("bob", "bob-pass")
+	d.admins[aliceDN] = true
+
+	l := newTestLDAP(t, d, "cn=admins,ou=groups,dc=example,dc=com")
+
+	// bob was made an administrator locally, but is not in the group
+	err := l.db.ProvisionUser("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.db.SetAdmin("bob", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for username, want := range map[string]bool{"alice": true, "bob": false} {
+		err = l.Authenticate(username, username+"-pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		isAdmin, err := l.db.IsAdmin(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isAdmin != want {
+			t.Errorf("%s: stored admin status = %v, want %v", username, isAdmin, want)
+		}
+
+		isAdmin, err = l.IsAdmin(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isAdmin != want {
+			t.Errorf("%s: IsAdmin() = %v, want %v", username, isAdmin, want)
+		}
+	}
+
+	// Removal from the group is noticed once the cached answer expires
+	delete(d.admins, aliceDN)
+	l.users = make(map[string]ldapUser)
+
+	isAdmin, err := l.IsAdmin("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isAdmin {
+		t.Error("IsAdmin(alice) = true after removal from the admin group")
+	}
+
+	isAdmin, err = l.db.IsAdmin("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isAdmin {
+		t.Error("stored admin status still true after removal from the admin group")
+	}
+}
+
+func TestLDAPUserExists(t *testing.T) {
+	d := newFakeDirectory()
+	d.addUser("alice", "alice-pass")
+	l := newTestLDAP(t, d, "")
+
+	exists, err := l.UserExists("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("UserExists(alice) = false")
+	}
+
+	// The answer is cached
+	delete(d.users, "alice")
+	exists, err = l.UserExists("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("UserExists(alice) = false before the cached answer expired")
+	}
+
+	l.users = make(map[string]ldapUser)
+	exists, err = l.UserExists("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("UserExists(alice) = true after removal from the directory")
+	}
+}
+
+func TestLDAPTLSConfig(t *testing.T) {
+	l, err := newLDAPAuthenticator(LDAPConfig{
+		URL:                "ldap://ldap.example.com:389",
+		StartTLS:           true,
+		InsecureSkipVerify: true,
+		UserBaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:         "(uid=%s)",
+	}, newTestDB(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.tlsConfig.ServerName != "ldap.example.com" {
+		t.Errorf("ServerName = %q, want ldap.example.com", l.tlsConfig.ServerName)
+	}
+	if !l.tlsConfig.InsecureSkipVerify {
+		t.Error("InsecureSkipVerify was not carried over")
+	}
+}
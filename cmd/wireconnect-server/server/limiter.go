@@ -20,14 +20,14 @@ type bucket struct {
 	*ratelimit.Bucket
 }
 
-func NewLimiter() *rateLimiter {
+func NewLimiter(fillInterval time.Duration, limit int64) *rateLimiter {
 	purgeInterval := 1 * time.Hour
 	purgeCheckDuration := 10 * time.Minute
 
 	r := &rateLimiter{
 		buckets:      make(map[string]*bucket),
-		fillInterval: 60 * time.Second,
-		limit:        5,
+		fillInterval: fillInterval,
+		limit:        limit,
 		mu:           &sync.RWMutex{},
 	}
 
@@ -40,6 +40,17 @@ func NewLimiter() *rateLimiter {
 	return r
 }
 
+// setLimit changes the limit used for new buckets and clears the existing ones
+func (r *rateLimiter) setLimit(fillInterval time.Duration, limit int64) {
+	r.mu.Lock()
+	if fillInterval != r.fillInterval || limit != r.limit {
+		r.fillInterval = fillInterval
+		r.limit = limit
+		r.buckets = make(map[string]*bucket)
+	}
+	r.mu.Unlock()
+}
+
 func (r *rateLimiter) getBans() []string {
 	banList := []string{}
 	r.mu.Lock()
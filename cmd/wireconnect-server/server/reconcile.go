@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// existingLink returns the WireGuard link with the given name, or nil if there is no such link
+func existingLink(name string) (netlink.Link, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if link.Type() != "wireguard" {
+		return nil, fmt.Errorf("Interface %s exists but is not WireGuard interface", name)
+	}
+
+	return link, nil
+}
+
+// pruneUnknownPeers removes peers from the active interfaces that do not belong to an
+// active session, such as those left behind by a server that did not shut down cleanly.
+func (s *Server) pruneUnknownPeers() error {
+	known := make(map[wgtypes.Key]bool)
+	for _, peers := range s.activePeers {
+		for _, sess := range peers {
+			known[sess.PublicKey] = true
+		}
+	}
+
+	for _, link := range s.activeInterfaces {
+		name := link.Attrs().Name
+
+		dev, err := s.wgClient.Device(name)
+		if err != nil {
+			return err
+		}
+
+		removals := []wgtypes.PeerConfig{}
+		for _, peer := range dev.Peers {
+			if known[peer.PublicKey] {
+				continue
+			}
+
+			log.Printf("Removing unknown peer %s from interface %s\n", peer.PublicKey, name)
+			removals = append(
+				removals,
+				wgtypes.PeerConfig{
+					PublicKey:  peer.PublicKey,
+					Remove:     true,
+					UpdateOnly: true,
+				},
+			)
+		}
+
+		if len(removals) == 0 {
+			continue
+		}
+
+		err = s.wgClient.ConfigureDevice(name, wgtypes.Config{Peers: removals})
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Removed %d unknown peers from interface %s\n", len(removals), name)
+	}
+
+	return nil
+}
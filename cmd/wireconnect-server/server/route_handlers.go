@@ -1,15 +1,23 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/sector-f/wireconnect"
 	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-var limiter = NewLimiter()
+// Linux limits interface names to IFNAMSIZ (16) bytes including the NUL terminator
+const maxIfaceNameLen = 15
 
 func (s *Server) createPeerHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
 	jsonDecoder := json.NewDecoder(r.Body)
@@ -20,16 +28,30 @@ func (s *Server) createPeerHandler(r *http.Request) (*wireconnect.SuccessRespons
 		return nil, wireconnect.ParseJsonError
 	}
 
-	if request.UserName == "" || request.PeerName == "" || request.Address == "" || request.ServerInterface == "" {
+	if request.UserName == "" || request.PeerName == "" || request.ServerInterface == "" {
 		return nil, wireconnect.IncompleteReqError
 	}
 
-	err = s.db.CreatePeer(request)
+	addrs, err := s.db.CreatePeer(request)
 	if err != nil {
-		return nil, wireconnect.DatabaseError
+		return nil, peerDBError(err)
 	}
 
-	return &wireconnect.SuccessResponse{http.StatusCreated, fmt.Sprintf("Created peer: %s\n", request.PeerName)}, nil
+	return &wireconnect.SuccessResponse{http.StatusCreated, fmt.Sprintf("Created peer %s with address %s\n", request.PeerName, database.AddressList(addrs))}, nil
+}
+
+// peerDBError converts an error from creating or modifying a peer into an ErrorResponse
+func peerDBError(err error) error {
+	switch err {
+	case database.ErrAddressInUse, database.ErrNoFreeAddress:
+		return wireconnect.ErrorResponse{http.StatusConflict, err.Error()}
+	case database.ErrAddressOutOfRange, database.ErrInvalidAddress, database.ErrTooManyAddresses:
+		return wireconnect.ErrorResponse{http.StatusBadRequest, err.Error()}
+	case sql.ErrNoRows:
+		return wireconnect.ErrorResponse{http.StatusNotFound, "No interface with that name exists"}
+	default:
+		return wireconnect.DatabaseError
+	}
 }
 
 func (s *Server) getInterfacesHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
@@ -42,20 +64,38 @@ func (s *Server) getInterfacesHandler(r *http.Request) (*wireconnect.SuccessResp
 	for _, iface := range interfaces {
 		wireIfaces = append(
 			wireIfaces,
-			wireconnect.ServerInterface{
-				Name:      iface.Name,
-				Addresses: iface.Addresses,
-			},
+			ifaceInfo(iface),
 		)
 	}
 
 	return &wireconnect.SuccessResponse{http.StatusOK, wireIfaces}, nil
 }
 
+func ifaceInfo(iface database.DBIface) wireconnect.ServerInterface {
+	info := wireconnect.ServerInterface{
+		Name:            iface.Name,
+		Addresses:       iface.Addresses,
+		CreateOnStartup: iface.CreateOnStartup,
+		ListenPort:      iface.ListenPort,
+	}
+
+	if iface.PrivateKey != nil {
+		info.PublicKey = iface.PrivateKey.PublicKey().String()
+	}
+
+	if iface.NextPrivateKey != nil {
+		info.NextPublicKey = iface.NextPrivateKey.PublicKey().String()
+		rotationTime := iface.KeyRotationTime
+		info.KeyRotationTime = &rotationTime
+	}
+
+	return info
+}
+
 func (s *Server) connectHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
 	jsonDecoder := json.NewDecoder(r.Body)
 
-	username, _, _ := r.BasicAuth()
+	username := requestUser(r)
 
 	request := wireconnect.ConnectionRequest{}
 	err := jsonDecoder.Decode(&request)
@@ -72,20 +112,29 @@ func (s *Server) connectHandler(r *http.Request) (*wireconnect.SuccessResponse,
 		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No peer with that name exists"}
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	err = s.makeIface(peer.DBIface)
 	if err != nil {
 		return nil, wireconnect.DatabaseError
 	}
 
-	err = s.addPeer(username, request)
+	err = s.addPeer(username, request, sourceAddress(r))
 	if err != nil {
 		return nil, wireconnect.DatabaseError
 	}
 
+	clientAddrs := []string{}
+	for _, addr := range peer.Addresses {
+		clientAddrs = append(clientAddrs, addr.String())
+	}
+
 	wgDev, _ := s.wgClient.Device(peer.DBIface.Name)
 	resp := wireconnect.ConnectionReply{
 		PublicKey:       wgDev.PublicKey.String(),
-		ClientAddress:   peer.Address.String(),
+		ClientAddress:   peer.Addresses[0].String(),
+		ClientAddresses: clientAddrs,
 		EndpointAddress: peer.EndpointAddress.String(),
 		EndpointPort:    wgDev.ListenPort,
 	}
@@ -93,15 +142,51 @@ func (s *Server) connectHandler(r *http.Request) (*wireconnect.SuccessResponse,
 	return &wireconnect.SuccessResponse{http.StatusOK, resp}, nil
 }
 
+func (s *Server) heartbeatHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	username := requestUser(r)
+
+	request := wireconnect.HeartbeatRequest{}
+	err := jsonDecoder.Decode(&request)
+	if err != nil {
+		return nil, wireconnect.ParseJsonError
+	}
+
+	if request.PeerName == "" || request.PublicKey == "" {
+		return nil, wireconnect.IncompleteReqError
+	}
+
+	peer := s.db.GetPeer(username, request.PeerName)
+	if peer == nil {
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No peer with that name exists"}
+	}
+
+	reply := wireconnect.HeartbeatReply{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, present := s.activePeers[username][request.PeerName]
+	reply.Active = present && sess.PublicKey.String() == request.PublicKey
+
+	wgDev, err := s.wgClient.Device(peer.DBIface.Name)
+	if err == nil {
+		reply.ServerPublicKey = wgDev.PublicKey.String()
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, reply}, nil
+}
+
 func (s *Server) getBansHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
-	bans := wireconnect.BanList{limiter.getBans()}
+	bans := wireconnect.BanList{s.limiter.getBans()}
 	return &wireconnect.SuccessResponse{http.StatusOK, bans}, nil
 }
 
 func (s *Server) disconnectHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
 	jsonDecoder := json.NewDecoder(r.Body)
 
-	username, _, _ := r.BasicAuth()
+	username := requestUser(r)
 
 	request := wireconnect.DisconnectionRequest{}
 	err := jsonDecoder.Decode(&request)
@@ -113,7 +198,9 @@ func (s *Server) disconnectHandler(r *http.Request) (*wireconnect.SuccessRespons
 		return nil, wireconnect.IncompleteReqError
 	}
 
+	s.mu.Lock()
 	err = s.removePeer(username, request.PeerName)
+	s.mu.Unlock()
 	if err != nil {
 		return nil, wireconnect.IncompleteReqError
 	}
@@ -147,7 +234,19 @@ func (s *Server) addUserHandler(r *http.Request) (*wireconnect.SuccessResponse,
 }
 
 func (s *Server) listPeersHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
-	username, _, _ := r.BasicAuth()
+	username := requestUser(r)
+
+	if r.URL.Query().Get("all") == "true" {
+		isAdmin, err := s.auth.IsAdmin(username)
+		if err != nil {
+			return nil, wireconnect.DatabaseError
+		}
+		if !isAdmin {
+			return nil, wireconnect.ErrorResponse{http.StatusUnauthorized, "Only administrators can list all peers"}
+		}
+
+		return s.listAllPeers()
+	}
 
 	peers := s.db.ListPeers(username)
 	if peers == nil {
@@ -156,3 +255,781 @@ func (s *Server) listPeersHandler(r *http.Request) (*wireconnect.SuccessResponse
 
 	return &wireconnect.SuccessResponse{http.StatusOK, peers}, nil
 }
+
+func (s *Server) deletePeerHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	peername := mux.Vars(r)["name"]
+
+	username := r.URL.Query().Get("user")
+	if username == "" {
+		username = requestUser(r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isActive(username, peername) {
+		err := s.removePeer(username, peername)
+		if err != nil {
+			return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to disconnect peer"}
+		}
+	}
+
+	err := s.db.DeletePeer(username, peername)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No peer with that name exists"}
+	default:
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, fmt.Sprintf("Deleted peer: %s\n", peername)}, nil
+}
+
+func (s *Server) modifyPeerHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	peername := mux.Vars(r)["name"]
+
+	username := r.URL.Query().Get("user")
+	if username == "" {
+		username = requestUser(r)
+	}
+
+	request := wireconnect.ModifyPeerRequest{}
+	err := jsonDecoder.Decode(&request)
+	if err != nil {
+		return nil, wireconnect.ParseJsonError
+	}
+
+	if request.Address == "" && request.EndpointAddress == "" && request.ServerInterface == "" {
+		return nil, wireconnect.IncompleteReqError
+	}
+
+	if request.EndpointAddress != "" && net.ParseIP(request.EndpointAddress) == nil {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Invalid endpoint address"}
+	}
+
+	if request.ServerInterface != "" {
+		_, err = s.db.Interface(request.ServerInterface)
+		if err != nil {
+			return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "No interface with that name exists"}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldPeer := s.db.GetPeer(username, peername)
+	if oldPeer == nil {
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No peer with that name exists"}
+	}
+
+	err = s.db.ModifyPeer(username, peername, request)
+	if err != nil {
+		return nil, peerDBError(err)
+	}
+
+	err = s.updatePeer(username, oldPeer)
+	if err != nil {
+		return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to update active peer"}
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, fmt.Sprintf("Modified peer: %s\n", peername)}, nil
+}
+
+func (s *Server) listUsersHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	users, err := s.db.ListUsers()
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	wireUsers := []wireconnect.User{}
+	for _, user := range users {
+		wireUsers = append(
+			wireUsers,
+			wireconnect.User{
+				Name:               user.Username,
+				IsAdmin:            user.IsAdmin,
+				MaxSessionLifetime: int(user.MaxSessionLifetime.Seconds()),
+			},
+		)
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, wireUsers}, nil
+}
+
+func (s *Server) deleteUserHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	requester := requestUser(r)
+	username := mux.Vars(r)["name"]
+
+	if username == requester {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Cannot delete own user"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.removeUserPeers(username)
+	if err != nil {
+		return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to disconnect user's peers"}
+	}
+
+	err = s.db.DeleteUser(username)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No user with that name exists"}
+	default:
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, fmt.Sprintf("Deleted user: %s\n", username)}, nil
+}
+
+func (s *Server) modifyUserHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	requester := requestUser(r)
+	username := mux.Vars(r)["name"]
+
+	request := wireconnect.ModifyUserRequest{}
+	err := jsonDecoder.Decode(&request)
+	if err != nil {
+		return nil, wireconnect.ParseJsonError
+	}
+
+	if request.IsAdmin == nil && request.MaxSessionLifetime == nil {
+		return nil, wireconnect.IncompleteReqError
+	}
+
+	if request.IsAdmin != nil && username == requester && !*request.IsAdmin {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Cannot remove own administrator privileges"}
+	}
+
+	if request.MaxSessionLifetime != nil && *request.MaxSessionLifetime < 0 {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Maximum session lifetime cannot be negative"}
+	}
+
+	if request.IsAdmin != nil {
+		err = s.db.SetAdmin(username, *request.IsAdmin)
+		switch err {
+		case nil:
+		case sql.ErrNoRows:
+			return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No user with that name exists"}
+		default:
+			return nil, wireconnect.DatabaseError
+		}
+	}
+
+	if request.MaxSessionLifetime != nil {
+		lifetime := time.Duration(*request.MaxSessionLifetime) * time.Second
+
+		err = s.db.SetMaxSessionLifetime(username, lifetime)
+		switch err {
+		case nil:
+		case sql.ErrNoRows:
+			return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No user with that name exists"}
+		default:
+			return nil, wireconnect.DatabaseError
+		}
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, fmt.Sprintf("Modified user: %s\n", username)}, nil
+}
+
+func (s *Server) changePasswordHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	username := requestUser(r)
+
+	request := wireconnect.ChangePasswordRequest{}
+	err := jsonDecoder.Decode(&request)
+	if err != nil {
+		return nil, wireconnect.ParseJsonError
+	}
+
+	if request.Password == "" || request.CurrentPassword == "" {
+		return nil, wireconnect.IncompleteReqError
+	}
+
+	if _, local := s.auth.(*database.ServiceDB); !local {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Passwords are managed by the authentication backend"}
+	}
+
+	// The request may have been authenticated with a token or API key, which must
+	// not be enough to take over the account
+	err = s.db.Authenticate(username, request.CurrentPassword)
+	if err != nil {
+		return nil, wireconnect.ErrorResponse{http.StatusForbidden, "Current password is incorrect"}
+	}
+
+	err = s.db.SetPassword(username, []byte(request.Password))
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, "Password changed"}, nil
+}
+
+func (s *Server) listAllPeers() (*wireconnect.SuccessResponse, error) {
+	configs, err := s.db.ListAllPeers()
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	devices := make(map[string]*wgtypes.Device)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := []wireconnect.Peer{}
+	for _, config := range configs {
+		peer := wireconnect.Peer{
+			Name:            config.Name,
+			Address:         database.AddressList(config.Addresses),
+			EndpointAddress: config.EndpointAddress.String(),
+			ServerInterface: config.DBIface.Name,
+			Owner:           config.Owner,
+		}
+
+		sess, present := s.activePeers[config.Owner][config.Name]
+		if present {
+			peer.Connected = true
+
+			dev, cached := devices[config.DBIface.Name]
+			if !cached {
+				dev, err = s.wgClient.Device(config.DBIface.Name)
+				if err != nil {
+					dev = nil
+				}
+				devices[config.DBIface.Name] = dev
+			}
+
+			if dev != nil {
+				peer.Status = peerStatus(dev, sess.PublicKey)
+			}
+		}
+
+		peers = append(peers, peer)
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, peers}, nil
+}
+
+func (s *Server) createInterfaceHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	request := wireconnect.CreateInterfaceRequest{}
+	err := jsonDecoder.Decode(&request)
+	if err != nil {
+		return nil, wireconnect.ParseJsonError
+	}
+
+	if request.Name == "" || len(request.Addresses) == 0 {
+		return nil, wireconnect.IncompleteReqError
+	}
+
+	if len(request.Name) > maxIfaceNameLen {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Interface name is too long"}
+	}
+
+	addresses, err := database.CidrList(strings.Join(request.Addresses, ","))
+	if err != nil {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Invalid interface address"}
+	}
+
+	if !validPort(request.ListenPort) {
+		return nil, wireconnect.InvalidPortError
+	}
+
+	_, err = s.db.Interface(request.Name)
+	switch err {
+	case sql.ErrNoRows:
+	case nil:
+		return nil, wireconnect.ErrorResponse{http.StatusConflict, "An interface with that name already exists"}
+	default:
+		return nil, wireconnect.DatabaseError
+	}
+
+	defaults := s.currentSettings().ifaceDefaults
+
+	iface := database.DBIface{
+		Name:            request.Name,
+		CreateOnStartup: defaults.CreateOnStartup,
+		ListenPort:      request.ListenPort,
+		Addresses:       addresses,
+	}
+	if iface.ListenPort == 0 {
+		iface.ListenPort = defaults.ListenPort
+	}
+	if request.CreateOnStartup != nil {
+		iface.CreateOnStartup = *request.CreateOnStartup
+	}
+
+	err = s.db.AddIface(iface)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	log.Printf("Creating interface %v\n", iface.Name)
+	s.mu.Lock()
+	err = s.makeIface(&iface)
+	if err != nil {
+		log.Println(err)
+
+		// Undo the partial creation so that the request can be retried
+		err = s.removeIface(iface.Name)
+		if err != nil {
+			log.Println(err)
+		}
+		s.mu.Unlock()
+
+		err = s.db.DeleteIface(iface.Name)
+		if err != nil {
+			log.Println(err)
+		}
+
+		return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to create interface"}
+	}
+	s.mu.Unlock()
+
+	return &wireconnect.SuccessResponse{http.StatusCreated, fmt.Sprintf("Created interface: %s\n", iface.Name)}, nil
+}
+
+func (s *Server) modifyInterfaceHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	name := mux.Vars(r)["name"]
+
+	request := wireconnect.ModifyInterfaceRequest{}
+	err := jsonDecoder.Decode(&request)
+	if err != nil {
+		return nil, wireconnect.ParseJsonError
+	}
+
+	if len(request.Addresses) == 0 && request.CreateOnStartup == nil && request.ListenPort == nil {
+		return nil, wireconnect.IncompleteReqError
+	}
+
+	if request.ListenPort != nil && !validPort(*request.ListenPort) {
+		return nil, wireconnect.InvalidPortError
+	}
+
+	var addresses []wireconnect.Address
+	if len(request.Addresses) > 0 {
+		addresses, err = database.CidrList(strings.Join(request.Addresses, ","))
+		if err != nil {
+			return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Invalid interface address"}
+		}
+	}
+
+	err = s.db.ModifyIface(name, addresses, request.CreateOnStartup, request.ListenPort)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No interface with that name exists"}
+	case database.ErrPeerOutOfRange:
+		return nil, wireconnect.ErrorResponse{http.StatusConflict, err.Error()}
+	default:
+		return nil, wireconnect.DatabaseError
+	}
+
+	iface, err := s.db.Interface(name)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.setIfaceAddresses(iface)
+	if err != nil {
+		log.Println(err)
+		return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to update interface addresses"}
+	}
+
+	if request.ListenPort != nil {
+		err = s.setIfaceListenPort(iface)
+		if err != nil {
+			log.Println(err)
+			return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to update interface listen port"}
+		}
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, fmt.Sprintf("Modified interface: %s\n", name)}, nil
+}
+
+func (s *Server) deleteInterfaceHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	name := mux.Vars(r)["name"]
+
+	err := s.db.DeleteIface(name)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No interface with that name exists"}
+	case database.ErrIfaceInUse:
+		return nil, wireconnect.ErrorResponse{http.StatusConflict, err.Error()}
+	default:
+		return nil, wireconnect.DatabaseError
+	}
+
+	s.mu.Lock()
+	err = s.removeIface(name)
+	s.mu.Unlock()
+	if err != nil {
+		log.Println(err)
+		return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to delete interface"}
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, fmt.Sprintf("Deleted interface: %s\n", name)}, nil
+}
+
+func (s *Server) rotateKeyHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	name := mux.Vars(r)["name"]
+
+	request := wireconnect.RotateKeyRequest{}
+	err := jsonDecoder.Decode(&request)
+	if err != nil {
+		return nil, wireconnect.ParseJsonError
+	}
+
+	if request.GracePeriod < 0 {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Grace period cannot be negative"}
+	}
+
+	_, err = s.db.Interface(name)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No interface with that name exists"}
+	default:
+		return nil, wireconnect.DatabaseError
+	}
+
+	newKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to generate private key"}
+	}
+
+	rotationTime := time.Now().Add(time.Duration(request.GracePeriod) * time.Second)
+
+	err = s.db.ScheduleIfaceKeyRotation(name, newKey, rotationTime)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	iface, err := s.db.Interface(name)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+	s.scheduleKeyRotation(iface)
+
+	reply := wireconnect.KeyRotationReply{
+		PublicKey:    newKey.PublicKey().String(),
+		RotationTime: rotationTime,
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, reply}, nil
+}
+
+func (s *Server) loginHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	reply, err := s.login(requestUser(r))
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, reply}, nil
+}
+
+func (s *Server) refreshHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	reply, err := s.refresh(requestToken(r))
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, reply}, nil
+}
+
+func (s *Server) logoutHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	err := s.db.DeleteToken(requestToken(r).ID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, "Logged out"}, nil
+}
+
+func (s *Server) listTokensHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	tokens, err := s.db.ListTokens(r.URL.Query().Get("user"))
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	wireTokens := []wireconnect.Token{}
+	for _, token := range tokens {
+		wireTokens = append(
+			wireTokens,
+			wireconnect.Token{
+				ID:            token.ID,
+				User:          token.Username,
+				IssuedAt:      token.IssuedAt,
+				AccessExpiry:  token.AccessExpiry,
+				RefreshExpiry: token.RefreshExpiry,
+			},
+		)
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, wireTokens}, nil
+}
+
+func (s *Server) revokeTokenHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	id := mux.Vars(r)["id"]
+
+	err := s.db.DeleteToken(id)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No token with that ID exists"}
+	default:
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, fmt.Sprintf("Revoked token: %s\n", id)}, nil
+}
+
+func (s *Server) createAPIKeyHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	requester := requestUser(r)
+
+	request := wireconnect.CreateAPIKeyRequest{}
+	err := jsonDecoder.Decode(&request)
+	if err != nil {
+		return nil, wireconnect.ParseJsonError
+	}
+
+	if request.Name == "" {
+		return nil, wireconnect.IncompleteReqError
+	}
+
+	for _, scope := range request.Scopes {
+		if !validScope(scope) {
+			return nil, wireconnect.ErrorResponse{http.StatusBadRequest, fmt.Sprintf("Invalid scope: %s", scope)}
+		}
+	}
+
+	// Otherwise a restricted key could be used to create an unrestricted one
+	if key := requestAPIKey(r); key != nil && len(key.Scopes) > 0 {
+		return nil, wireconnect.ErrorResponse{http.StatusForbidden, "API keys with scopes cannot create other keys"}
+	}
+
+	username := requester
+	if request.UserName != "" && request.UserName != requester {
+		isAdmin, err := s.auth.IsAdmin(requester)
+		if err != nil {
+			return nil, wireconnect.DatabaseError
+		}
+		if !isAdmin {
+			return nil, wireconnect.ErrorResponse{http.StatusUnauthorized, "Only administrators can create keys for other users"}
+		}
+
+		username = request.UserName
+	}
+
+	key, id, hash, err := newAPIKey()
+	if err != nil {
+		return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to generate API key"}
+	}
+
+	dbKey := database.APIKey{
+		ID:        id,
+		Name:      request.Name,
+		Username:  username,
+		Hash:      hash,
+		Scopes:    request.Scopes,
+		CreatedAt: time.Now(),
+	}
+
+	err = s.db.AddAPIKey(dbKey)
+	if err != nil {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Failed to create API key; the user may not exist or may already have a key with that name"}
+	}
+
+	reply := apiKeyInfo(dbKey)
+	reply.Key = key
+
+	return &wireconnect.SuccessResponse{http.StatusCreated, reply}, nil
+}
+
+func apiKeyInfo(key database.APIKey) wireconnect.APIKey {
+	return wireconnect.APIKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		User:      key.Username,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+	}
+}
+
+func (s *Server) listAPIKeysHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	username := requestUser(r)
+
+	if r.URL.Query().Get("all") == "true" {
+		isAdmin, err := s.auth.IsAdmin(username)
+		if err != nil {
+			return nil, wireconnect.DatabaseError
+		}
+		if !isAdmin {
+			return nil, wireconnect.ErrorResponse{http.StatusUnauthorized, "Only administrators can list all API keys"}
+		}
+
+		username = ""
+	}
+
+	keys, err := s.db.ListAPIKeys(username)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	wireKeys := []wireconnect.APIKey{}
+	for _, key := range keys {
+		wireKeys = append(wireKeys, apiKeyInfo(key))
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, wireKeys}, nil
+}
+
+func (s *Server) deleteAPIKeyHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	id := mux.Vars(r)["id"]
+	username := requestUser(r)
+
+	// Administrators may delete any key
+	isAdmin, err := s.auth.IsAdmin(username)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+	if isAdmin {
+		username = ""
+	}
+
+	err = s.db.DeleteAPIKey(id, username)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No API key with that ID exists"}
+	default:
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, fmt.Sprintf("Deleted API key: %s\n", id)}, nil
+}
+
+func (s *Server) enrollTOTPHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	username := requestUser(r)
+
+	current, _, err := s.db.TOTPSecret(username)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+	if current != "" {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "TOTP is already enabled"}
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to generate TOTP secret"}
+	}
+
+	err = s.db.SetPendingTOTP(username, secret)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	reply := wireconnect.TOTPEnrollment{
+		Secret: secret,
+		URL:    totpURL(username, secret),
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, reply}, nil
+}
+
+func (s *Server) verifyTOTPHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	jsonDecoder := json.NewDecoder(r.Body)
+
+	username := requestUser(r)
+
+	request := wireconnect.VerifyTOTPRequest{}
+	err := jsonDecoder.Decode(&request)
+	if err != nil {
+		return nil, wireconnect.ParseJsonError
+	}
+
+	if request.Code == "" {
+		return nil, wireconnect.IncompleteReqError
+	}
+
+	pending, err := s.db.PendingTOTP(username)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+	if pending == "" {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "No TOTP enrollment in progress"}
+	}
+
+	step := matchTOTP(pending, request.Code, 0, time.Now())
+	if step == 0 {
+		return nil, wireconnect.ErrorResponse{http.StatusBadRequest, "Invalid code"}
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, wireconnect.ErrorResponse{http.StatusInternalServerError, "Failed to generate recovery codes"}
+	}
+
+	err = s.db.ActivateTOTP(username, hashes, step)
+	if err != nil {
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, wireconnect.TOTPActivation{codes}}, nil
+}
+
+func (s *Server) disableTOTPHandler(r *http.Request) (*wireconnect.SuccessResponse, error) {
+	username := mux.Vars(r)["name"]
+
+	// Users must prove that they still have their device (or a recovery code), since
+	// the request may have been authenticated with a token or API key alone.
+	// Administrators can disable TOTP for others without one, e.g. after a device is lost.
+	if username == "" {
+		username = requestUser(r)
+
+		request := wireconnect.DisableTOTPRequest{}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			return nil, wireconnect.ParseJsonError
+		}
+
+		err = s.checkOTP(username, request.Code)
+		switch err {
+		case nil:
+		case errOTPRequired, errInvalidOTP:
+			return nil, wireconnect.ErrorResponse{http.StatusForbidden, "A valid one-time or recovery code is required"}
+		default:
+			return nil, wireconnect.DatabaseError
+		}
+	}
+
+	err := s.db.DisableTOTP(username)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return nil, wireconnect.ErrorResponse{http.StatusNotFound, "No user with that name exists"}
+	default:
+		return nil, wireconnect.DatabaseError
+	}
+
+	return &wireconnect.SuccessResponse{http.StatusOK, fmt.Sprintf("Disabled TOTP for user: %s\n", username)}, nil
+}
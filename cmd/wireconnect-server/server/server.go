@@ -2,11 +2,12 @@ package server
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -15,11 +16,11 @@ import (
 	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
 	"github.com/vishvananda/netlink"
 	"golang.zx2c4.com/wireguard/wgctrl"
-	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 type route struct {
 	pattern  string
+	scope    string // API keys need this scope, if they are limited to certain routes
 	handlers []handler
 }
 
@@ -27,33 +28,150 @@ type handler struct {
 	method      string
 	handlerFunc apiFunc
 	needsAdmin  bool
+	needsOTP    bool // Users with TOTP enabled must send a one-time code with their password or certificate
+	auth        authMode
 }
 
+// Config holds the server's settings. Fields marked as reloadable can be
+// changed while the server is running using Reload.
 type Config struct {
-	Address      string
-	DSN          string
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
+	Address              string          `toml:"address"`
+	DSN                  string          `toml:"database"`
+	SecretKeyFile        string          `toml:"secret_key_file"` // Optional; used to encrypt interface private keys
+	ReadTimeout          time.Duration   `toml:"read_timeout"`
+	WriteTimeout         time.Duration   `toml:"write_timeout"`
+	IdleTimeout          time.Duration   `toml:"idle_timeout"`           // Reloadable; peers without a handshake for this long are removed; 0 disables
+	ReapInterval         time.Duration   `toml:"reap_interval"`          // How often to check for expired sessions
+	RestoreSessions      bool            `toml:"restore_sessions"`       // Re-add peers that were connected when the server last stopped
+	KeepInterfaces       bool            `toml:"keep_interfaces"`        // Reloadable; leave WireGuard interfaces and peers in place when stopping
+	ShutdownTimeout      time.Duration   `toml:"shutdown_timeout"`       // Reloadable; how long to wait for in-flight requests when stopping
+	AccessTokenLifetime  time.Duration   `toml:"access_token_lifetime"`  // Reloadable
+	RefreshTokenLifetime time.Duration   `toml:"refresh_token_lifetime"` // Reloadable
+	RateLimit            RateLimitConfig `toml:"rate_limit"`             // Reloadable
+	InterfaceDefaults    IfaceDefaults   `toml:"interface_defaults"`     // Reloadable
+	Auth                 AuthConfig      `toml:"auth"`
+	ClientCertUser       string          `toml:"client_cert_user"` // Certificate field holding the username: "cn", "email" or "dns"
+}
+
+// RateLimitConfig controls how many failed authentication attempts an address may make
+type RateLimitConfig struct {
+	FillInterval time.Duration `toml:"fill_interval"` // Time taken to regain Limit attempts
+	Limit        int64         `toml:"limit"`
+}
+
+// IfaceDefaults holds the settings used for interfaces when none are specified
+type IfaceDefaults struct {
+	Name            string `toml:"name"` // Name of the interface created on first run
+	ListenPort      int    `toml:"listen_port"`
+	CreateOnStartup bool   `toml:"create_on_startup"`
 }
 
 func NewConfig() Config {
 	return Config{
-		Address:      "0.0.0.0:8080",
-		DSN:          "",
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+		Address:              "0.0.0.0:8900",
+		DSN:                  "",
+		ReadTimeout:          5 * time.Second,
+		WriteTimeout:         5 * time.Second,
+		IdleTimeout:          0,
+		ReapInterval:         1 * time.Minute,
+		RestoreSessions:      true,
+		KeepInterfaces:       false,
+		ShutdownTimeout:      10 * time.Second,
+		AccessTokenLifetime:  15 * time.Minute,
+		RefreshTokenLifetime: 7 * 24 * time.Hour,
+		ClientCertUser:       "cn",
+		RateLimit: RateLimitConfig{
+			FillInterval: 60 * time.Second,
+			Limit:        5,
+		},
+		InterfaceDefaults: IfaceDefaults{
+			Name:            "wireconnect0",
+			ListenPort:      0,
+			CreateOnStartup: true,
+		},
 	}
 }
 
+// Validate checks for values that the server cannot run with
+func (conf Config) Validate() error {
+	switch {
+	case conf.ReapInterval <= 0:
+		return errors.New("reap_interval must be positive")
+	case conf.AccessTokenLifetime <= 0 || conf.RefreshTokenLifetime <= 0:
+		return errors.New("access_token_lifetime and refresh_token_lifetime must be positive")
+	case conf.RateLimit.FillInterval <= 0 || conf.RateLimit.Limit <= 0:
+		return errors.New("rate_limit.fill_interval and rate_limit.limit must be positive")
+	case conf.IdleTimeout < 0:
+		return errors.New("idle_timeout must not be negative")
+	case conf.ShutdownTimeout < 0:
+		return errors.New("shutdown_timeout must not be negative")
+	case !validClientCertUser(conf.ClientCertUser):
+		return fmt.Errorf("Invalid client_cert_user %s", conf.ClientCertUser)
+	}
+
+	return nil
+}
+
 type Server struct {
 	db               *database.ServiceDB
 	wgClient         *wgctrl.Client
+	limiter          *rateLimiter
+	auth             Authenticator
+	clientCertUser   string
+	signingKey       []byte     // Used to sign bearer tokens
+	mu               sync.Mutex // Guards activeInterfaces, activePeers and changes to WireGuard devices
 	activeInterfaces []netlink.Link
-	activePeers      map[string]map[string]wgtypes.Key // Map users to peers; O(1) time
+	activePeers      map[string]map[string]*session // Map users to peers; O(1) time
+	settingsMu       sync.RWMutex
+	settings         settings
 	*http.Server
 }
 
+// settings holds the parts of the configuration that can be reloaded
+type settings struct {
+	idleTimeout     time.Duration
+	keepInterfaces  bool
+	shutdownTimeout time.Duration
+	ifaceDefaults   IfaceDefaults
+
+	accessTokenLifetime  time.Duration
+	refreshTokenLifetime time.Duration
+}
+
+func newSettings(conf Config) settings {
+	return settings{
+		idleTimeout:     conf.IdleTimeout,
+		keepInterfaces:  conf.KeepInterfaces,
+		shutdownTimeout: conf.ShutdownTimeout,
+		ifaceDefaults:   conf.InterfaceDefaults,
+
+		accessTokenLifetime:  conf.AccessTokenLifetime,
+		refreshTokenLifetime: conf.RefreshTokenLifetime,
+	}
+}
+
+func (s *Server) currentSettings() settings {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+
+	return s.settings
+}
+
+// Reload applies the reloadable parts of conf to the running server
+func (s *Server) Reload(conf Config) {
+	s.limiter.setLimit(conf.RateLimit.FillInterval, conf.RateLimit.Limit)
+
+	s.settingsMu.Lock()
+	s.settings = newSettings(conf)
+	s.settingsMu.Unlock()
+}
+
 func NewServer(conf Config) (*Server, error) {
+	err := conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := sql.Open("sqlite3", conf.DSN)
 	if err != nil {
 		return nil, err
@@ -69,6 +187,24 @@ func NewServer(conf Config) (*Server, error) {
 		return nil, err
 	}
 
+	if conf.SecretKeyFile != "" {
+		secretKey, err := database.LoadSecretKey(conf.SecretKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		serviceDB.SetSecretKey(secretKey)
+	}
+
+	signingKey, err := serviceDB.SigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newAuthenticator(conf.Auth, serviceDB)
+	if err != nil {
+		return nil, err
+	}
+
 	httpServer := &http.Server{
 		Addr:         conf.Address,
 		ReadTimeout:  conf.ReadTimeout,
@@ -84,7 +220,12 @@ func NewServer(conf Config) (*Server, error) {
 		db:               serviceDB,
 		wgClient:         wgc,
 		activeInterfaces: []netlink.Link{},
-		activePeers:      make(map[string]map[string]wgtypes.Key),
+		activePeers:      make(map[string]map[string]*session),
+		limiter:          NewLimiter(conf.RateLimit.FillInterval, conf.RateLimit.Limit),
+		signingKey:       signingKey,
+		auth:             auth,
+		clientCertUser:   conf.ClientCertUser,
+		settings:         newSettings(conf),
 		Server:           httpServer,
 	}
 
@@ -92,7 +233,8 @@ func NewServer(conf Config) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	if userCount == 0 {
+	// Other backends provision users when they first log in
+	if userCount == 0 && (conf.Auth.Backend == "" || conf.Auth.Backend == "database") {
 		server.makeFirstUser()
 	}
 
@@ -117,32 +259,27 @@ func NewServer(conf Config) (*Server, error) {
 				log.Fatal(err)
 			}
 		}
-	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		server.scheduleKeyRotation(&iface)
+	}
 
-	go func() {
-		signal := <-sigChan
+	err = server.restoreSessions(conf.RestoreSessions)
+	if err != nil {
+		return nil, err
+	}
 
-		switch signal {
-		case syscall.SIGINT:
-			log.Println("Caught SIGINT")
-		case syscall.SIGTERM:
-			log.Println("Caught SIGTERM")
-		default:
-			// Shouldn't occur
-			log.Println("Caught signal")
-		}
+	err = server.pruneUnknownPeers()
+	if err != nil {
+		return nil, err
+	}
 
-		server.Shutdown()
-		os.Exit(1)
-	}()
+	go server.reapSessions(conf.ReapInterval)
 
 	router := mux.NewRouter()
 	routes := []route{
 		route{
 			pattern: "/bans",
+			scope:   "bans",
 			handlers: []handler{
 				handler{
 					method:      "GET",
@@ -153,16 +290,19 @@ func NewServer(conf Config) (*Server, error) {
 		},
 		route{
 			pattern: "/connect",
+			scope:   "peers",
 			handlers: []handler{
 				handler{
 					method:      "POST",
 					handlerFunc: server.connectHandler,
 					needsAdmin:  false,
+					needsOTP:    true,
 				},
 			},
 		},
 		route{
 			pattern: "/disconnect",
+			scope:   "peers",
 			handlers: []handler{
 				handler{
 					method:      "POST",
@@ -171,8 +311,103 @@ func NewServer(conf Config) (*Server, error) {
 				},
 			},
 		},
+		route{
+			pattern: "/heartbeat",
+			scope:   "peers",
+			handlers: []handler{
+				handler{
+					method:      "POST",
+					handlerFunc: server.heartbeatHandler,
+					needsAdmin:  false,
+				},
+			},
+		},
+		route{
+			pattern: "/login",
+			handlers: []handler{
+				handler{
+					method:      "POST",
+					handlerFunc: server.loginHandler,
+					needsAdmin:  false,
+					needsOTP:    true,
+					auth:        authPassword,
+				},
+			},
+		},
+		route{
+			pattern: "/login/refresh",
+			handlers: []handler{
+				handler{
+					method:      "POST",
+					handlerFunc: server.refreshHandler,
+					needsAdmin:  false,
+					auth:        authRefresh,
+				},
+			},
+		},
+		route{
+			pattern: "/logout",
+			handlers: []handler{
+				handler{
+					method:      "POST",
+					handlerFunc: server.logoutHandler,
+					needsAdmin:  false,
+					auth:        authRefresh,
+				},
+			},
+		},
+		route{
+			pattern: "/tokens",
+			scope:   "tokens",
+			handlers: []handler{
+				handler{
+					method:      "GET",
+					handlerFunc: server.listTokensHandler,
+					needsAdmin:  true,
+				},
+			},
+		},
+		route{
+			pattern: "/tokens/{id}",
+			scope:   "tokens",
+			handlers: []handler{
+				handler{
+					method:      "DELETE",
+					handlerFunc: server.revokeTokenHandler,
+					needsAdmin:  true,
+				},
+			},
+		},
+		route{
+			pattern: "/keys",
+			scope:   "keys",
+			handlers: []handler{
+				handler{
+					method:      "POST",
+					handlerFunc: server.createAPIKeyHandler,
+					needsAdmin:  false,
+				},
+				handler{
+					method:      "GET",
+					handlerFunc: server.listAPIKeysHandler,
+					needsAdmin:  false,
+				},
+			},
+		},
+		route{
+			pattern: "/keys/{id}",
+			scope:   "keys",
+			handlers: []handler{
+				handler{
+					method:      "DELETE",
+					handlerFunc: server.deleteAPIKeyHandler,
+					needsAdmin:  false,
+				},
+			},
+		},
 		route{
 			pattern: "/peers",
+			scope:   "peers",
 			handlers: []handler{
 				handler{
 					method:      "POST",
@@ -186,24 +421,144 @@ func NewServer(conf Config) (*Server, error) {
 				},
 			},
 		},
+		route{
+			pattern: "/peers/{name}",
+			scope:   "peers",
+			handlers: []handler{
+				handler{
+					method:      "DELETE",
+					handlerFunc: server.deletePeerHandler,
+					needsAdmin:  true,
+				},
+				handler{
+					method:      "PATCH",
+					handlerFunc: server.modifyPeerHandler,
+					needsAdmin:  true,
+				},
+			},
+		},
 		route{
 			pattern: "/interfaces",
+			scope:   "interfaces",
 			handlers: []handler{
 				handler{
 					method:      "GET",
 					handlerFunc: server.getInterfacesHandler,
 					needsAdmin:  false,
 				},
+				handler{
+					method:      "POST",
+					handlerFunc: server.createInterfaceHandler,
+					needsAdmin:  true,
+				},
+			},
+		},
+		route{
+			pattern: "/interfaces/{name}",
+			scope:   "interfaces",
+			handlers: []handler{
+				handler{
+					method:      "PATCH",
+					handlerFunc: server.modifyInterfaceHandler,
+					needsAdmin:  true,
+				},
+				handler{
+					method:      "DELETE",
+					handlerFunc: server.deleteInterfaceHandler,
+					needsAdmin:  true,
+				},
+			},
+		},
+		route{
+			pattern: "/interfaces/{name}/rotate-key",
+			scope:   "interfaces",
+			handlers: []handler{
+				handler{
+					method:      "POST",
+					handlerFunc: server.rotateKeyHandler,
+					needsAdmin:  true,
+				},
 			},
 		},
 		route{
 			pattern: "/users",
+			scope:   "users",
 			handlers: []handler{
 				handler{
 					method:      "POST",
 					handlerFunc: server.addUserHandler,
 					needsAdmin:  true,
 				},
+				handler{
+					method:      "GET",
+					handlerFunc: server.listUsersHandler,
+					needsAdmin:  true,
+				},
+			},
+		},
+		route{
+			pattern: "/users/me/password",
+			scope:   "users",
+			handlers: []handler{
+				handler{
+					method:      "PUT",
+					handlerFunc: server.changePasswordHandler,
+					needsAdmin:  false,
+				},
+			},
+		},
+		route{
+			pattern: "/users/me/totp",
+			scope:   "users",
+			handlers: []handler{
+				handler{
+					method:      "POST",
+					handlerFunc: server.enrollTOTPHandler,
+					needsAdmin:  false,
+				},
+				handler{
+					method:      "DELETE",
+					handlerFunc: server.disableTOTPHandler,
+					needsAdmin:  false,
+				},
+			},
+		},
+		route{
+			pattern: "/users/me/totp/verify",
+			scope:   "users",
+			handlers: []handler{
+				handler{
+					method:      "POST",
+					handlerFunc: server.verifyTOTPHandler,
+					needsAdmin:  false,
+				},
+			},
+		},
+		route{
+			pattern: "/users/{name}/totp",
+			scope:   "users",
+			handlers: []handler{
+				handler{
+					method:      "DELETE",
+					handlerFunc: server.disableTOTPHandler,
+					needsAdmin:  true,
+				},
+			},
+		},
+		route{
+			pattern: "/users/{name}",
+			scope:   "users",
+			handlers: []handler{
+				handler{
+					method:      "DELETE",
+					handlerFunc: server.deleteUserHandler,
+					needsAdmin:  true,
+				},
+				handler{
+					method:      "PATCH",
+					handlerFunc: server.modifyUserHandler,
+					needsAdmin:  true,
+				},
 			},
 		},
 	}
@@ -217,7 +572,9 @@ func NewServer(conf Config) (*Server, error) {
 				h = server.adminHandler(h)
 			}
 
-			h = server.authLimit(h)
+			h = server.scopeHandler(h, route.scope)
+
+			h = server.authLimit(h, handler.auth, handler.needsOTP)
 
 			methodHandler[handler.method] = h
 
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type session struct {
+	PublicKey     wgtypes.Key
+	ConnectedAt   time.Time
+	SourceAddress string
+}
+
+// restoreSessions re-adds the peers of sessions that were active when the server
+// last stopped. If restore is false, the recorded sessions are discarded instead.
+func (s *Server) restoreSessions(restore bool) error {
+	sessions, err := s.db.Sessions()
+	if err != nil {
+		return err
+	}
+
+	if !restore {
+		if len(sessions) > 0 {
+			log.Printf("Discarding %d sessions from previous run\n", len(sessions))
+		}
+		return s.db.FlushSessions()
+	}
+
+	for _, dbSess := range sessions {
+		peerConfig := s.db.GetPeer(dbSess.Username, dbSess.PeerName)
+		if peerConfig == nil {
+			s.db.DeleteSession(dbSess.Username, dbSess.PeerName)
+			continue
+		}
+
+		err = s.makeIface(peerConfig.DBIface)
+		if err == nil {
+			err = s.configurePeer(peerConfig, dbSess.PublicKey)
+		}
+		if err != nil {
+			log.Printf("Failed to restore session of peer %s/%s: %v\n", dbSess.Username, dbSess.PeerName, err)
+			s.db.DeleteSession(dbSess.Username, dbSess.PeerName)
+			continue
+		}
+
+		s.setActive(dbSess.Username, dbSess.PeerName, &session{
+			PublicKey:     dbSess.PublicKey,
+			ConnectedAt:   dbSess.ConnectedAt,
+			SourceAddress: dbSess.SourceAddress,
+		})
+
+		log.Printf("Restored session of peer %s/%s\n", dbSess.Username, dbSess.PeerName)
+	}
+
+	return nil
+}
+
+// reapSessions periodically removes peers that have been idle for longer than the idle
+// timeout or that have exceeded their user's maximum session lifetime. An idle timeout
+// of 0 disables idle expiry.
+func (s *Server) reapSessions(interval time.Duration) {
+	for _ = range time.Tick(interval) {
+		s.expireSessions(s.currentSettings().idleTimeout)
+	}
+}
+
+func (s *Server) expireSessions(idleTimeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := make(map[string]*wgtypes.Device)
+	now := time.Now()
+
+	for username, peers := range s.activePeers {
+		maxLifetime, err := s.db.MaxSessionLifetime(username)
+		if err != nil {
+			log.Printf("Failed to read maximum session lifetime of user %s: %v\n", username, err)
+			maxLifetime = 0
+		}
+
+		for peername, sess := range peers {
+			if maxLifetime > 0 && now.Sub(sess.ConnectedAt) > maxLifetime {
+				s.expirePeer(username, peername, "maximum session lifetime reached")
+				continue
+			}
+
+			if idleTimeout <= 0 {
+				continue
+			}
+
+			peerConfig := s.db.GetPeer(username, peername)
+			if peerConfig == nil {
+				continue
+			}
+
+			dev, cached := devices[peerConfig.DBIface.Name]
+			if !cached {
+				dev, err = s.wgClient.Device(peerConfig.DBIface.Name)
+				if err != nil {
+					dev = nil
+				}
+				devices[peerConfig.DBIface.Name] = dev
+			}
+
+			// Peers that have never completed a handshake are idle since they connected
+			lastActive := sess.ConnectedAt
+			if dev != nil {
+				status := peerStatus(dev, sess.PublicKey)
+				if status != nil && status.LastHandshakeTime.After(lastActive) {
+					lastActive = status.LastHandshakeTime
+				}
+			}
+
+			if now.Sub(lastActive) > idleTimeout {
+				s.expirePeer(username, peername, "idle timeout reached")
+			}
+		}
+	}
+}
+
+func (s *Server) expirePeer(username, peername, reason string) {
+	err := s.removePeer(username, peername)
+	if err != nil {
+		log.Printf("Failed to expire session of peer %s/%s: %v\n", username, peername, err)
+		return
+	}
+
+	log.Printf("Expired session of peer %s/%s: %s\n", username, peername, reason)
+}
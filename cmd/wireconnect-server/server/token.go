@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
+)
+
+// Kinds of bearer token. Access tokens authenticate API requests; refresh tokens
+// can only be used to obtain new access tokens.
+const (
+	tokenAccess  = "access"
+	tokenRefresh = "refresh"
+)
+
+var errInvalidToken = errors.New("Invalid token")
+
+// signToken returns a token of the form KIND.ID.EXPIRY.SIGNATURE
+func (s *Server) signToken(kind, id string, expiry time.Time) string {
+	payload := strings.Join([]string{kind, id, strconv.FormatInt(expiry.Unix(), 10)}, ".")
+
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(payload))
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken checks a token's signature and expiry and returns the session it belongs to
+func (s *Server) verifyToken(token, kind string) (*database.Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[0] != kind {
+		return nil, errInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(strings.Join(parts[:3], ".")))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errInvalidToken
+	}
+
+	expiry, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	now := time.Now()
+	if now.Unix() >= expiry {
+		return nil, errInvalidToken
+	}
+
+	// The session must still exist, so that revoked tokens are rejected
+	dbToken, err := s.db.GetToken(parts[1])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	if !now.Before(dbToken.RefreshExpiry) {
+		return nil, errInvalidToken
+	}
+
+	return dbToken, nil
+}
+
+// login starts a new session for username and returns its tokens
+func (s *Server) login(username string) (*wireconnect.LoginReply, error) {
+	current := s.currentSettings()
+	now := time.Now()
+
+	err := s.db.PurgeExpiredTokens(now)
+	if err != nil {
+		return nil, err
+	}
+
+	idBytes := make([]byte, 16)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token := database.Token{
+		ID:            hex.EncodeToString(idBytes),
+		Username:      username,
+		IssuedAt:      now,
+		AccessExpiry:  now.Add(current.accessTokenLifetime),
+		RefreshExpiry: now.Add(current.refreshTokenLifetime),
+	}
+
+	err = s.db.AddToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wireconnect.LoginReply{
+		AccessToken:   s.signToken(tokenAccess, token.ID, token.AccessExpiry),
+		AccessExpiry:  token.AccessExpiry,
+		RefreshToken:  s.signToken(tokenRefresh, token.ID, token.RefreshExpiry),
+		RefreshExpiry: token.RefreshExpiry,
+	}, nil
+}
+
+// refresh issues a new access token for an existing session.
+// The access token never outlives the session's refresh token.
+func (s *Server) refresh(token *database.Token) (*wireconnect.LoginReply, error) {
+	expiry := time.Now().Add(s.currentSettings().accessTokenLifetime)
+	if expiry.After(token.RefreshExpiry) {
+		expiry = token.RefreshExpiry
+	}
+
+	err := s.db.SetAccessExpiry(token.ID, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wireconnect.LoginReply{
+		AccessToken:   s.signToken(tokenAccess, token.ID, expiry),
+		AccessExpiry:  expiry,
+		RefreshExpiry: token.RefreshExpiry,
+	}, nil
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters from RFC 6238; these are what authenticator apps assume by default
+const (
+	totpPeriod = 30
+	totpDigits = 6
+
+	recoveryCodeCount = 10
+)
+
+var (
+	errOTPRequired = errors.New("One-time code required")
+	errInvalidOTP  = errors.New("Invalid one-time code")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random base32-encoded secret
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// totpURL returns an otpauth:// URL that authenticator apps can import
+func totpURL(username, secret string) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/wireconnect:" + username,
+		RawQuery: url.Values{
+			"secret": {secret},
+			"issuer": {"wireconnect"},
+		}.Encode(),
+	}
+
+	return u.String()
+}
+
+// totpCode computes the code for a time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+// matchTOTP returns the time step that code is valid for at now, allowing one step of
+// clock skew, or 0 if it is not valid for any step after lastStep
+func matchTOTP(secret, code string, lastStep int64, now time.Time) int64 {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - 1; step <= current+1; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+
+	return 0
+}
+
+// newRecoveryCodes returns single-use codes along with the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := []string{}
+	hashes := []string{}
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(base32NoPadding.EncodeToString(raw))
+		codes = append(codes, code[:8]+"-"+code[8:])
+		hashes = append(hashes, hashSecret(normalizeRecoveryCode(code)))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(code, "-", "", -1))
+}
+
+// checkOTP verifies the one-time code sent with a password or client certificate. Users who have not
+// enabled TOTP do not need a code. Both TOTP codes and recovery codes are accepted,
+// and neither can be used twice.
+func (s *Server) checkOTP(username, code string) error {
+	secret, lastStep, err := s.db.TOTPSecret(username)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return nil
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return errOTPRequired
+	}
+
+	if step := matchTOTP(secret, code, lastStep, time.Now()); step != 0 {
+		// A concurrent request may have used the same code since lastStep was read
+		err = s.db.UseTOTPStep(username, step)
+		if err == sql.ErrNoRows {
+			return errInvalidOTP
+		}
+		return err
+	}
+
+	err = s.db.UseRecoveryCode(username, hashSecret(normalizeRecoveryCode(code)))
+	if err == sql.ErrNoRows {
+		return errInvalidOTP
+	}
+
+	return err
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secret used by the SHA-1 test vectors in RFC 6238 appendix B
+const rfc6238Secret = "12345678901234567890"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+
+	for _, test := range tests {
+		got := totpCode([]byte(rfc6238Secret), test.unix/totpPeriod)
+		if got != test.want {
+			t.Errorf("totpCode() at %d = %s, want %s", test.unix, got, test.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := base32NoPadding.EncodeToString([]byte(rfc6238Secret))
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+	code := func(step int64) string {
+		return totpCode([]byte(rfc6238Secret), step)
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		want     int64
+	}{
+		{"current step", code(step), 0, step},
+		{"previous step", code(step - 1), 0, step - 1},
+		{"next step", code(step + 1), 0, step + 1},
+		{"two steps behind", code(step - 2), 0, 0},
+		{"two steps ahead", code(step + 2), 0, 0},
+		{"wrong code", "000000", 0, 0},
+		{"step already used", code(step), step, 0},
+		{"earlier step than last used", code(step - 1), step, 0},
+		{"later step than last used", code(step + 1), step, step + 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := matchTOTP(secret, test.code, test.lastStep, now)
+			if got != test.want {
+				t.Errorf("matchTOTP() = %d, want %d", got, test.want)
+			}
+		})
+	}
+
+	// Authenticator apps may show the secret in lower case
+	if matchTOTP(strings.ToLower(secret), code(step), 0, now) != step {
+		t.Error("lower case secret was not accepted")
+	}
+}
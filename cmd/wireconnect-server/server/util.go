@@ -6,6 +6,7 @@ import (
 	"math/bits"
 	"net"
 	"os"
+	"strconv"
 	"syscall"
 
 	"github.com/sector-f/wireconnect"
@@ -23,6 +24,11 @@ func cidr(mask net.IPMask) uint {
 	return cidrmask
 }
 
+// validPort reports whether port is a usable listen port, with 0 meaning any port
+func validPort(port int) bool {
+	return port >= 0 && port <= 65535
+}
+
 func (s *Server) makeFirstUser() error {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Creating initial admin user")
@@ -46,7 +52,7 @@ func (s *Server) makeFirstUser() error {
 
 func (s *Server) makeFirstIface() error {
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Println("Creating initial wireguard interface.")
+	fmt.Printf("Creating initial wireguard interface %s.\n", s.currentSettings().ifaceDefaults.Name)
 
 	var addresses []wireconnect.Address
 
@@ -67,10 +73,40 @@ func (s *Server) makeFirstIface() error {
 		break
 	}
 
+	defaults := s.currentSettings().ifaceDefaults
+	listenPort := defaults.ListenPort
+
+	for {
+		if defaults.ListenPort == 0 {
+			fmt.Println("Please enter the UDP port to listen on (leave blank to let the kernel choose).")
+		} else {
+			fmt.Printf("Please enter the UDP port to listen on (leave blank for %d).\n", defaults.ListenPort)
+		}
+
+		fmt.Print("> ")
+		port, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		port = port[:len(port)-1]
+
+		if port == "" {
+			break
+		}
+
+		listenPort, err = strconv.Atoi(port)
+		if err != nil || !validPort(listenPort) {
+			continue
+		}
+		break
+	}
+
 	return s.db.AddIface(
 		database.DBIface{
-			Name:      "wireconnect0",
-			Addresses: addresses,
+			Name:            defaults.Name,
+			CreateOnStartup: true,
+			ListenPort:      listenPort,
+			Addresses:       addresses,
 		},
 	)
 }
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net"
+	"time"
 
 	"github.com/sector-f/wireconnect"
 	"github.com/sector-f/wireconnect/cmd/wireconnect-server/database"
@@ -22,22 +24,163 @@ func (s *Server) makeIface(iface *database.DBIface) error {
 		}
 	}
 
-	linkAttrs := netlink.NewLinkAttrs()
+	// The interface may have been left behind by a server that did not shut down cleanly
+	link, err := existingLink(iface.Name)
+	if err != nil {
+		return err
+	}
+
+	if link != nil {
+		log.Printf("Adopting existing interface %s\n", iface.Name)
+		s.activeInterfaces = append(s.activeInterfaces, link)
+
+		err = s.setIfaceAddresses(iface)
+		if err != nil {
+			return err
+		}
+	} else {
+		linkAttrs := netlink.NewLinkAttrs()
+
+		linkAttrs.Name = iface.Name
+		link = &netlink.GenericLink{
+			linkAttrs,
+			"wireguard",
+		}
 
-	linkAttrs.Name = iface.Name
-	link := &netlink.GenericLink{
-		linkAttrs,
-		"wireguard",
+		err = netlink.LinkAdd(link)
+		if err != nil {
+			return err
+		}
+
+		s.activeInterfaces = append(s.activeInterfaces, link)
+
+		for _, addr := range iface.Addresses {
+			log.Printf("\t%v/%v\n", addr.Address, cidr(addr.Mask))
+
+			netAddr := &net.IPNet{
+				IP:   addr.Address,
+				Mask: addr.Mask,
+			}
+
+			nlAddr := netlink.Addr{IPNet: netAddr}
+
+			err = netlink.AddrAdd(link, &nlAddr)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	err := netlink.LinkAdd(link)
+	privkey, err := s.ifacePrivateKey(iface)
 	if err != nil {
 		return err
 	}
 
-	s.activeInterfaces = append(s.activeInterfaces, link)
+	wgConfig := wgtypes.Config{
+		PrivateKey: &privkey,
+	}
+
+	if iface.ListenPort != 0 {
+		wgConfig.ListenPort = &iface.ListenPort
+	}
+
+	err = s.wgClient.ConfigureDevice(iface.Name, wgConfig)
+	if err != nil {
+		return err
+	}
+
+	err = netlink.LinkSetUp(link)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ifacePrivateKey returns the interface's stored private key, generating and storing one
+// if the interface does not have one yet. A pending key rotation that is due is applied first.
+func (s *Server) ifacePrivateKey(iface *database.DBIface) (wgtypes.Key, error) {
+	if iface.NextPrivateKey != nil && !time.Now().Before(iface.KeyRotationTime) {
+		err := s.db.SetIfacePrivateKey(iface.Name, *iface.NextPrivateKey)
+		if err != nil {
+			return wgtypes.Key{}, err
+		}
+
+		iface.PrivateKey = iface.NextPrivateKey
+		iface.NextPrivateKey = nil
+	}
+
+	if iface.PrivateKey != nil {
+		return *iface.PrivateKey, nil
+	}
+
+	privkey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+
+	err = s.db.SetIfacePrivateKey(iface.Name, privkey)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+
+	iface.PrivateKey = &privkey
+	return privkey, nil
+}
+
+// activeIface returns the link for the named interface if the server has created it
+func (s *Server) activeIface(name string) (int, netlink.Link) {
+	for i, link := range s.activeInterfaces {
+		if link.Attrs().Name == name {
+			return i, link
+		}
+	}
+
+	return -1, nil
+}
+
+// setIfaceAddresses makes the addresses of an active interface match those in iface.
+// It does nothing if the interface is not active.
+func (s *Server) setIfaceAddresses(iface *database.DBIface) error {
+	_, link := s.activeIface(iface.Name)
+	if link == nil {
+		return nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, addr := range iface.Addresses {
+		wanted[addr.String()] = true
+	}
+
+	oldAddrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool)
+	for _, addr := range oldAddrs {
+		if addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		addrStr := wireconnect.Address{Address: addr.IP, Mask: addr.Mask}.String()
+		if wanted[addrStr] {
+			present[addrStr] = true
+			continue
+		}
+
+		log.Printf("Removing address %s from interface %s\n", addrStr, iface.Name)
+		err = netlink.AddrDel(link, &addr)
+		if err != nil {
+			return err
+		}
+	}
 
 	for _, addr := range iface.Addresses {
+		if present[addr.String()] {
+			continue
+		}
+
 		log.Printf("\t%v/%v\n", addr.Address, cidr(addr.Mask))
 
 		netAddr := &net.IPNet{
@@ -53,40 +196,97 @@ func (s *Server) makeIface(iface *database.DBIface) error {
 		}
 	}
 
-	privkey, err := wgtypes.GeneratePrivateKey()
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
-	wgConfig := wgtypes.Config{
-		PrivateKey: &privkey,
+// setIfaceListenPort changes the listen port of an active interface.
+// It does nothing if the interface is not active.
+func (s *Server) setIfaceListenPort(iface *database.DBIface) error {
+	_, link := s.activeIface(iface.Name)
+	if link == nil {
+		return nil
 	}
 
-	err = s.wgClient.ConfigureDevice(iface.Name, wgConfig)
-	if err != nil {
-		return err
+	port := iface.ListenPort
+	return s.wgClient.ConfigureDevice(iface.Name, wgtypes.Config{ListenPort: &port})
+}
+
+// removeIface deletes the named interface if it is active
+func (s *Server) removeIface(name string) error {
+	i, link := s.activeIface(name)
+	if link == nil {
+		return nil
 	}
 
-	err = netlink.LinkSetUp(link)
+	log.Printf("Deleting interface: %s\n", name)
+	err := netlink.LinkDel(link)
 	if err != nil {
 		return err
 	}
 
+	s.activeInterfaces = append(s.activeInterfaces[:i], s.activeInterfaces[i+1:]...)
 	return nil
 }
 
-func (s *Server) addPeer(username string, request wireconnect.ConnectionRequest) error {
+// allowedIPs returns a single-host network (/32 or /128) for each of the peer's addresses
+func allowedIPs(addrs []wireconnect.Address) []net.IPNet {
+	nets := []net.IPNet{}
+	for _, addr := range addrs {
+		if ip4 := addr.Address.To4(); ip4 != nil {
+			nets = append(nets, net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+		} else {
+			nets = append(nets, net.IPNet{IP: addr.Address, Mask: net.CIDRMask(128, 128)})
+		}
+	}
+
+	return nets
+}
+
+// addPeer adds the requesting client to the peer's interface and records the session.
+func (s *Server) addPeer(username string, request wireconnect.ConnectionRequest, sourceAddr string) error {
 	peerConfig := s.db.GetPeer(username, request.PeerName)
 	if peerConfig == nil {
 		return errors.New("Peer does not exist")
 	}
 
-	dev, err := s.wgClient.Device(peerConfig.DBIface.Name)
+	key, err := wgtypes.ParseKey(request.PublicKey)
 	if err != nil {
 		return err
 	}
 
-	key, err := wgtypes.ParseKey(request.PublicKey)
+	// A reconnecting client uses a new key, so the old one has to go
+	oldSess, present := s.activePeers[username][request.PeerName]
+	if present && oldSess.PublicKey != key {
+		err = s.removePeer(username, request.PeerName)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.configurePeer(peerConfig, key)
+	if err != nil {
+		return err
+	}
+
+	sess := &session{
+		PublicKey:     key,
+		ConnectedAt:   time.Now(),
+		SourceAddress: sourceAddr,
+	}
+	s.setActive(username, request.PeerName, sess)
+
+	return s.db.SaveSession(database.Session{
+		Username:      username,
+		PeerName:      request.PeerName,
+		PublicKey:     sess.PublicKey,
+		ConnectedAt:   sess.ConnectedAt,
+		SourceAddress: sess.SourceAddress,
+	})
+}
+
+// configurePeer adds a peer with the given public key to the peer's interface
+func (s *Server) configurePeer(peerConfig *database.PeerConfig, key wgtypes.Key) error {
+	dev, err := s.wgClient.Device(peerConfig.DBIface.Name)
 	if err != nil {
 		return err
 	}
@@ -105,35 +305,57 @@ func (s *Server) addPeer(username string, request wireconnect.ConnectionRequest)
 				Endpoint:                    nil, // TODO: Get this from http request IP?
 				PersistentKeepaliveInterval: nil,
 				ReplaceAllowedIPs:           true, // Probably not needed
-				AllowedIPs: []net.IPNet{
-					net.IPNet{
-						IP:   peerConfig.Address.Address,
-						Mask: net.IPv4Mask(255, 255, 255, 255),
-					},
-				},
+				AllowedIPs:                  allowedIPs(peerConfig.Addresses),
 			},
 		},
 	}
 
-	err = s.wgClient.ConfigureDevice(peerConfig.DBIface.Name, config)
-	if err != nil {
-		return err
-	}
+	return s.wgClient.ConfigureDevice(peerConfig.DBIface.Name, config)
+}
 
+func (s *Server) setActive(username, peername string, sess *session) {
 	usermap, present := s.activePeers[username]
 	if !present {
-		usermap = make(map[string]wgtypes.Key)
+		usermap = make(map[string]*session)
 		s.activePeers[username] = usermap
 	}
-	usermap[request.PeerName] = key
+	usermap[peername] = sess
+}
+
+// peerStatus returns the live state of the peer with the given public key,
+// or nil if the device has no such peer.
+func peerStatus(dev *wgtypes.Device, pubkey wgtypes.Key) *wireconnect.PeerStatus {
+	for _, peer := range dev.Peers {
+		if peer.PublicKey != pubkey {
+			continue
+		}
+
+		status := wireconnect.PeerStatus{
+			LastHandshakeTime: peer.LastHandshakeTime,
+			ReceiveBytes:      peer.ReceiveBytes,
+			TransmitBytes:     peer.TransmitBytes,
+		}
+		if peer.Endpoint != nil {
+			status.Endpoint = peer.Endpoint.String()
+		}
+
+		return &status
+	}
+
 	return nil
 }
 
+func (s *Server) isActive(username, peername string) bool {
+	_, present := s.activePeers[username][peername]
+	return present
+}
+
 func (s *Server) removePeer(username, peername string) error {
-	pubkey, present := s.activePeers[username][peername]
+	sess, present := s.activePeers[username][peername]
 	if !present {
 		return errors.New("Peer is not active")
 	}
+	pubkey := sess.PublicKey
 
 	peerConfig := s.db.GetPeer(username, peername)
 	if peerConfig == nil {
@@ -165,10 +387,98 @@ func (s *Server) removePeer(username, peername string) error {
 	}
 
 	delete(s.activePeers[username], peername)
+	return s.db.DeleteSession(username, peername)
+}
+
+// removeUserPeers removes all of a user's active peers from their WireGuard interfaces.
+func (s *Server) removeUserPeers(username string) error {
+	for peername := range s.activePeers[username] {
+		err := s.removePeer(username, peername)
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(s.activePeers, username)
 	return nil
 }
 
+// updatePeer applies changes made to an active peer's configuration to its
+// WireGuard interface. oldConfig is the peer's configuration prior to the change.
+func (s *Server) updatePeer(username string, oldConfig *database.PeerConfig) error {
+	sess, present := s.activePeers[username][oldConfig.Name]
+	if !present {
+		return nil
+	}
+	pubkey := sess.PublicKey
+
+	peerConfig := s.db.GetPeer(username, oldConfig.Name)
+	if peerConfig == nil {
+		return errors.New("Peer does not exist")
+	}
+
+	if peerConfig.DBIface.Name != oldConfig.DBIface.Name {
+		config := wgtypes.Config{
+			ReplacePeers: false,
+			Peers: []wgtypes.PeerConfig{
+				wgtypes.PeerConfig{
+					PublicKey:  pubkey,
+					Remove:     true,
+					UpdateOnly: true,
+				},
+			},
+		}
+
+		err := s.wgClient.ConfigureDevice(oldConfig.DBIface.Name, config)
+		if err != nil {
+			return err
+		}
+
+		err = s.makeIface(peerConfig.DBIface)
+		if err != nil {
+			return err
+		}
+	}
+
+	config := wgtypes.Config{
+		ReplacePeers: false,
+		Peers: []wgtypes.PeerConfig{
+			wgtypes.PeerConfig{
+				PublicKey:         pubkey,
+				ReplaceAllowedIPs: true,
+				AllowedIPs:        allowedIPs(peerConfig.Addresses),
+			},
+		},
+	}
+
+	return s.wgClient.ConfigureDevice(peerConfig.DBIface.Name, config)
+}
+
+// Stop stops accepting requests and waits for in-flight requests to finish until the
+// shutdown timeout expires. The WireGuard interfaces are then deleted, unless the server
+// was configured to keep them.
+func (s *Server) Stop() error {
+	current := s.currentSettings()
+
+	ctx, cancel := context.WithTimeout(context.Background(), current.shutdownTimeout)
+	defer cancel()
+
+	err := s.Server.Shutdown(ctx)
+
+	if current.keepInterfaces {
+		log.Println("Leaving WireGuard interfaces in place")
+	} else {
+		s.Shutdown()
+	}
+
+	return err
+}
+
+// Shutdown deletes the WireGuard interfaces created by the server
 func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	log.Println("Shutting down")
 
 	for _, link := range s.activeInterfaces {
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func addInterfaceCmd() *cobra.Command {
+	addInterfaceCmd := cobra.Command{
+		Use:           "add-interface IFACENAME",
+		Short:         "Create a WireGuard interface on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No interface specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			addresses, _ := cmd.Flags().GetStringSlice("address")
+			if len(addresses) == 0 {
+				return errors.New("No addresses specified")
+			}
+
+			listenPort, _ := cmd.Flags().GetInt("listen-port")
+
+			msg := &wireconnect.CreateInterfaceRequest{
+				Name:       args[0],
+				Addresses:  addresses,
+				ListenPort: listenPort,
+			}
+
+			if cmd.Flags().Changed("create-on-startup") {
+				createOnStartup, _ := cmd.Flags().GetBool("create-on-startup")
+				msg.CreateOnStartup = &createOnStartup
+			}
+
+			resp, err := doRequest("POST", "/interfaces", nil, msg)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusCreated {
+				return errorReply(resp)
+			}
+
+			fmt.Println("Interface created")
+			return nil
+		},
+	}
+
+	addInterfaceCmd.Flags().StringSliceP("address", "a", nil, "Comma-separated list of interface addresses in CIDR notation")
+	addInterfaceCmd.Flags().IntP("listen-port", "p", 0, "UDP port for the interface to listen on (Default: chosen by the kernel)")
+	addInterfaceCmd.Flags().Bool("create-on-startup", true, "Create the interface when the server starts")
+
+	return &addInterfaceCmd
+}
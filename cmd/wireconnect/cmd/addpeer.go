@@ -2,13 +2,12 @@ package cmd
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
+	"strings"
 
 	"github.com/sector-f/wireconnect"
 	"github.com/spf13/cobra"
@@ -27,30 +26,35 @@ func addPeerCmd() *cobra.Command {
 			if name == "" {
 				fmt.Print("Enter peer configuration name: ")
 				name, _ = reader.ReadString('\n')
+				name = strings.TrimSpace(name)
 			}
 
 			username, _ := cmd.Flags().GetString("username")
 			if username == "" {
 				fmt.Print("Enter username: ")
 				username, _ = reader.ReadString('\n')
+				username = strings.TrimSpace(username)
 			}
 
 			address, _ := cmd.Flags().GetString("address")
 			if address == "" {
-				fmt.Print("Enter peer address: ")
+				fmt.Print("Enter peer address (leave blank to allocate automatically): ")
 				address, _ = reader.ReadString('\n')
+				address = strings.TrimSpace(address)
 			}
 
 			endpointAddress, _ := cmd.Flags().GetString("endpoint-address")
 			if endpointAddress == "" {
 				fmt.Print("Enter endpoint address: ")
 				endpointAddress, _ = reader.ReadString('\n')
+				endpointAddress = strings.TrimSpace(endpointAddress)
 			}
 
 			serverInterface, _ := cmd.Flags().GetString("server-interface")
 			if serverInterface == "" {
 				fmt.Print("Enter server interface: ")
 				serverInterface, _ = reader.ReadString('\n')
+				serverInterface = strings.TrimSpace(serverInterface)
 			}
 
 			msg := &wireconnect.CreatePeerRequest{
@@ -61,60 +65,36 @@ func addPeerCmd() *cobra.Command {
 				ServerInterface: serverInterface,
 			}
 
-			jsonMsg, err := json.Marshal(msg)
+			resp, err := doRequest("POST", "/peers", nil, msg)
 			if err != nil {
 				return err
 			}
+			defer resp.Body.Close()
 
-			req := &http.Request{
-				Method: "POST",
-				URL: &url.URL{
-					Scheme: "https",
-					Host:   Server,
-					Path:   "/peers",
-				},
-				Body:   ioutil.NopCloser(bytes.NewBuffer(jsonMsg)),
-				Header: make(http.Header),
+			if resp.StatusCode != http.StatusCreated {
+				return errorReply(resp)
 			}
 
-			req.Header.Add("Content-Type", "application/json")
-			req.SetBasicAuth(Username, Password)
-
-			resp, err := Client.Do(req)
+			data, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
 				return err
 			}
-			defer resp.Body.Close()
 
-			if resp.StatusCode == http.StatusCreated {
-				_, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					return err
-				}
-
-				fmt.Println("Peer created")
-			} else {
-				data, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					return err
-				}
-
-				var reply string
-				err = json.Unmarshal(data, &reply)
-				if err != nil {
-					return err
-				}
-
-				fmt.Printf("Received %v: %v\n", resp.Status, reply)
+			var reply string
+			err = json.Unmarshal(data, &reply)
+			if err != nil {
+				return err
 			}
 
+			fmt.Print(reply)
+
 			return nil
 		},
 	}
 
 	addPeerCmd.Flags().StringP("name", "n", "", "Peer configuration name")
 	addPeerCmd.Flags().String("username", "", "Username of peer configuration's owner") // FIXME: can't use -u here because it's used in rootCmd
-	addPeerCmd.Flags().StringP("address", "a", "", "Peer's WireGuard address")
+	addPeerCmd.Flags().StringP("address", "a", "", "Peer's IPv4 and/or IPv6 WireGuard addresses, comma-separated (Default: next free addresses on the interface)")
 	addPeerCmd.Flags().StringP("endpoint-address", "e", "", "Endpoint address for peer to connect to")
 	addPeerCmd.Flags().StringP("server-interface", "i", "", "WireGuard interface on the server")
 
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// clientConfig is the layout of the client configuration file
+type clientConfig struct {
+	DefaultProfile string             `toml:"default_profile"`
+	Profiles       map[string]profile `toml:"profile"`
+	Pins           map[string]string  `toml:"pins"` // Server public key pins, saved by trust on first use
+
+	path string
+}
+
+// profile holds the settings used to reach one wireconnect server
+type profile struct {
+	Server   string `toml:"server"`
+	Username string `toml:"username"`
+	CAFile   string `toml:"ca_file"`
+	Insecure bool   `toml:"insecure"` // Skips certificate verification, but not the pin
+
+	PinSHA256       string `toml:"pin_sha256"` // Base64-encoded SHA-256 hash of the server's public key
+	TrustOnFirstUse bool   `toml:"trust_on_first_use"`
+
+	// Certificate and key used to authenticate to servers that accept client certificates
+	ClientCert string `toml:"client_cert"`
+	ClientKey  string `toml:"client_key"`
+
+	Peer string `toml:"peer"` // Used when no PEERNAME is given
+	TOTP bool   `toml:"totp"` // Prompt for a one-time code before sending requests
+
+	CredentialStore string `toml:"credential_store"` // "netrc" (default) or "keyring"
+	NetrcFile       string `toml:"netrc_file"`
+}
+
+// defaultConfigPath returns the path of the configuration file read when --config is not given
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "wireconnect", "config.toml")
+}
+
+// loadConfig reads the configuration file at path.
+// A missing file is only an error if required is set.
+func loadConfig(path string, required bool) (*clientConfig, error) {
+	config := &clientConfig{path: path}
+
+	if path == "" {
+		return config, nil
+	}
+
+	_, err := toml.DecodeFile(path, config)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return config, nil
+		}
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// profile returns the named profile, or the default profile if name is empty.
+// An empty profile is returned if no name is given and there is no default.
+func (c *clientConfig) profile(name string) (profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return profile{}, nil
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("Profile %s not found", name)
+	}
+
+	return p, nil
+}
+
+// tlsConfig builds the TLS configuration used to talk to the server.
+// verifier may be nil if the server's key is not pinned.
+func (p profile) tlsConfig(verifier *pinVerifier) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: p.Insecure,
+	}
+
+	if p.CAFile != "" {
+		pem, err := ioutil.ReadFile(p.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in CA file")
+		}
+		config.RootCAs = pool
+	}
+
+	if p.ClientCert != "" || p.ClientKey != "" {
+		if p.ClientCert == "" || p.ClientKey == "" {
+			return nil, errors.New("Both a client certificate and key must be specified")
+		}
+
+		cert, err := tls.LoadX509KeyPair(p.ClientCert, p.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	// A pin is checked even if insecure is set, which only skips verification of the chain
+	if verifier != nil {
+		verifier.apply(config)
+	}
+
+	return config, nil
+}
+
+// peerArg returns the peer named in args, falling back to the profile's default peer
+func peerArg(args []string) (string, error) {
+	if len(args) > 1 {
+		return "", errors.New("Too many arguments specified")
+	}
+
+	if len(args) == 1 {
+		return args[0], nil
+	}
+
+	if DefaultPeer == "" {
+		return "", errors.New("No peer specified")
+	}
+
+	return DefaultPeer, nil
+}
@@ -1,14 +1,11 @@
 package cmd
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
-	"net/url"
+	"time"
 
 	"github.com/sector-f/wireconnect"
 	"github.com/spf13/cobra"
@@ -17,166 +14,222 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
+// Name of the WireGuard interface created by the client
+const linkName = "wireconnect"
+
 func connectCmd() *cobra.Command {
 	connectCmd := cobra.Command{
-		Use:           "connect PEERNAME",
+		Use:           "connect [PEERNAME]",
 		Short:         "Connect to wireconnect VPN server",
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) == 0 {
-				return errors.New("No peer specified")
-			} else if len(args) > 1 {
-				return errors.New("Too many arguments specified")
-			}
-
-			privKey, err := wgtypes.GeneratePrivateKey()
+			peername, err := peerArg(args)
 			if err != nil {
 				return err
 			}
 
-			pubKey := privKey.PublicKey()
+			foreground, _ := cmd.Flags().GetBool("foreground")
+			heartbeat, _ := cmd.Flags().GetDuration("heartbeat")
+			keepalive, _ := cmd.Flags().GetDuration("keepalive")
 
-			msg := wireconnect.ConnectionRequest{
-				PeerName:  args[0],
-				PublicKey: pubKey.String(),
+			if !foreground {
+				keepalive = 0
+			} else if heartbeat <= 0 {
+				return errors.New("Heartbeat interval must be positive")
 			}
 
-			jsonMsg, err := json.Marshal(msg)
+			privKey, reply, err := connect(peername, keepalive)
 			if err != nil {
 				return err
 			}
 
-			req := &http.Request{
-				Method: "POST",
-				URL: &url.URL{
-					Scheme: "https",
-					Host:   Server,
-					Path:   "/connect",
-				},
-				Body:   ioutil.NopCloser(bytes.NewBuffer(jsonMsg)),
-				Header: make(http.Header),
-			}
-			req.Header.Add("Content-Type", "application/json")
-			req.SetBasicAuth(Username, Password)
-
-			resp, err := Client.Do(req)
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode == http.StatusOK {
-				data, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					return err
-				}
-
-				var reply wireconnect.ConnectionReply
-				err = json.Unmarshal(data, &reply)
-				if err != nil {
-					return err
-				}
-
-				addr, network, err := net.ParseCIDR(reply.ClientAddress)
-				if err != nil {
-					return err
-				}
-
-				endpointAddr := net.ParseIP(reply.EndpointAddress)
-				if endpointAddr == nil {
-					return errors.New("Invalid endpoint address")
-				}
-
-				// FIXME: should creation of the WireGuard interface be
-				// before or after connecting to the server?
-
-				wgClient, err := wgctrl.New()
-				if err != nil {
-					return err
-				}
-
-				serverPubKey, err := wgtypes.ParseKey(reply.PublicKey)
-				if err != nil {
-					return err
-				}
-
-				linkAttrs := netlink.NewLinkAttrs()
-				linkAttrs.Name = "wireconnect"
-				link := &netlink.GenericLink{
-					linkAttrs,
-					"wireguard",
-				}
-
-				err = netlink.LinkAdd(link)
-				if err != nil {
-					return err
-				}
-
-				netAddr := &net.IPNet{
-					IP:   addr,
-					Mask: network.Mask,
-				}
-
-				nlAddr := netlink.Addr{IPNet: netAddr}
-
-				err = netlink.AddrAdd(link, &nlAddr)
-				if err != nil {
-					return err
-				}
-
-				wgConfig := wgtypes.Config{
-					PrivateKey: &privKey,
-					Peers: []wgtypes.PeerConfig{
-						wgtypes.PeerConfig{
-							PublicKey:    serverPubKey,
-							Remove:       false,
-							UpdateOnly:   false,
-							PresharedKey: nil,
-							Endpoint: &net.UDPAddr{
-								IP:   endpointAddr,
-								Port: reply.EndpointPort,
-							},
-							PersistentKeepaliveInterval: nil,
-							ReplaceAllowedIPs:           true, // Probably not needed
-							AllowedIPs: []net.IPNet{
-								net.IPNet{
-									IP:   network.IP,
-									Mask: network.Mask,
-								},
-							},
-						},
-					},
-				}
-
-				err = wgClient.ConfigureDevice("wireconnect", wgConfig)
-				if err != nil {
-					return err
-				}
-
-				err = netlink.LinkSetUp(link)
-				if err != nil {
-					return err
-				}
-			} else {
-
-				data, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					return err
-				}
-
-				var reply string
-				err = json.Unmarshal(data, &reply)
-				if err != nil {
-					return err
-				}
-
-				fmt.Printf("Received %v: %v\n", resp.Status, reply)
+			if foreground {
+				return runForeground(peername, privKey, reply, heartbeat, keepalive)
 			}
 
 			return nil
 		},
 	}
 
+	connectCmd.Flags().BoolP("foreground", "f", false, "Stay running, reconnect when needed and disconnect on SIGINT/SIGTERM")
+	connectCmd.Flags().Duration("heartbeat", 30*time.Second, "Interval between heartbeats sent to the server in foreground mode")
+	connectCmd.Flags().Duration("keepalive", 25*time.Second, "WireGuard persistent keepalive interval in foreground mode (0 to disable)")
+
 	return &connectCmd
 }
+
+// connect generates a new key pair, registers it with the server and creates the local interface
+func connect(peername string, keepalive time.Duration) (wgtypes.Key, *wireconnect.ConnectionReply, error) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return wgtypes.Key{}, nil, err
+	}
+
+	reply, err := requestConnection(peername, privKey.PublicKey())
+	if err != nil {
+		return wgtypes.Key{}, nil, err
+	}
+
+	// FIXME: should creation of the WireGuard interface be
+	// before or after connecting to the server?
+
+	err = createLink(privKey, reply, keepalive)
+	if err != nil {
+		// Don't leave an unusable peer behind on the server
+		requestDisconnection(peername)
+		return wgtypes.Key{}, nil, err
+	}
+
+	return privKey, reply, nil
+}
+
+// requestConnection asks the server to add a peer with the given public key
+func requestConnection(peername string, pubKey wgtypes.Key) (*wireconnect.ConnectionReply, error) {
+	msg := wireconnect.ConnectionRequest{
+		PeerName:  peername,
+		PublicKey: pubKey.String(),
+	}
+
+	resp, err := doRequest("POST", "/connect", nil, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errorReply(resp)
+	}
+
+	var reply wireconnect.ConnectionReply
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+// createLink creates and configures the local WireGuard interface.
+// The interface is deleted again if any step after its creation fails.
+// A keepalive of 0 disables persistent keepalives.
+func createLink(privKey wgtypes.Key, reply *wireconnect.ConnectionReply, keepalive time.Duration) error {
+	clientAddrs := reply.ClientAddresses
+	if len(clientAddrs) == 0 {
+		// Server predates dual-stack support
+		clientAddrs = []string{reply.ClientAddress}
+	}
+
+	addrs := []*net.IPNet{}
+	networks := []net.IPNet{}
+	for _, clientAddr := range clientAddrs {
+		addr, network, err := net.ParseCIDR(clientAddr)
+		if err != nil {
+			return err
+		}
+
+		addrs = append(addrs, &net.IPNet{IP: addr, Mask: network.Mask})
+		networks = append(networks, *network)
+	}
+
+	endpointAddr := net.ParseIP(reply.EndpointAddress)
+	if endpointAddr == nil {
+		return errors.New("Invalid endpoint address")
+	}
+
+	serverPubKey, err := wgtypes.ParseKey(reply.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	wgClient, err := wgctrl.New()
+	if err != nil {
+		return err
+	}
+	defer wgClient.Close()
+
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Name = linkName
+	link := &netlink.GenericLink{
+		linkAttrs,
+		"wireguard",
+	}
+
+	err = netlink.LinkAdd(link)
+	if err != nil {
+		return err
+	}
+
+	err = configureLink(wgClient, link, privKey, serverPubKey, addrs, networks, endpointAddr, reply.EndpointPort, keepalive)
+	if err != nil {
+		netlink.LinkDel(link)
+		return err
+	}
+
+	return nil
+}
+
+func configureLink(
+	wgClient *wgctrl.Client,
+	link netlink.Link,
+	privKey wgtypes.Key,
+	serverPubKey wgtypes.Key,
+	addrs []*net.IPNet,
+	networks []net.IPNet,
+	endpointAddr net.IP,
+	endpointPort int,
+	keepalive time.Duration,
+) error {
+	for _, netAddr := range addrs {
+		nlAddr := netlink.Addr{IPNet: netAddr}
+
+		err := netlink.AddrAdd(link, &nlAddr)
+		if err != nil {
+			return err
+		}
+	}
+
+	var keepaliveInterval *time.Duration
+	if keepalive > 0 {
+		keepaliveInterval = &keepalive
+	}
+
+	wgConfig := wgtypes.Config{
+		PrivateKey: &privKey,
+		Peers: []wgtypes.PeerConfig{
+			wgtypes.PeerConfig{
+				PublicKey:    serverPubKey,
+				Remove:       false,
+				UpdateOnly:   false,
+				PresharedKey: nil,
+				Endpoint: &net.UDPAddr{
+					IP:   endpointAddr,
+					Port: endpointPort,
+				},
+				PersistentKeepaliveInterval: keepaliveInterval,
+				ReplaceAllowedIPs:           true, // Probably not needed
+				AllowedIPs:                  networks,
+			},
+		},
+	}
+
+	err := wgClient.ConfigureDevice(link.Attrs().Name, wgConfig)
+	if err != nil {
+		return err
+	}
+
+	return netlink.LinkSetUp(link)
+}
+
+// deleteLink deletes the local WireGuard interface if it exists
+func deleteLink() error {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	return netlink.LinkDel(link)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func createAPIKeyCmd() *cobra.Command {
+	createAPIKeyCmd := cobra.Command{
+		Use:           "create-api-key NAME",
+		Short:         "Create a long-lived API key on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No key name specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			username, _ := cmd.Flags().GetString("username")
+			scopes, _ := cmd.Flags().GetStringSlice("scope")
+
+			msg := &wireconnect.CreateAPIKeyRequest{
+				Name:     args[0],
+				UserName: username,
+				Scopes:   scopes,
+			}
+
+			resp, err := doRequest("POST", "/keys", nil, msg)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusCreated {
+				return errorReply(resp)
+			}
+
+			var key wireconnect.APIKey
+			err = json.NewDecoder(resp.Body).Decode(&key)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Created API key %s (ID %s). It will not be shown again:\n", key.Name, key.ID)
+			fmt.Println(key.Key)
+			return nil
+		},
+	}
+
+	createAPIKeyCmd.Flags().String("username", "", "Owner of the key (Default: current user)")
+	createAPIKeyCmd.Flags().StringSlice("scope", nil, "Restrict the key: read, peers, users, interfaces, tokens, keys or bans (may be repeated)")
+
+	return &createAPIKeyCmd
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Service name used for entries in the system keyring
+const keyringService = "wireconnect"
+
+var errNoCredentials = errors.New("No stored credentials")
+
+// credentialStore saves usernames and passwords, keyed by server address
+type credentialStore interface {
+	get(server string) (username, password string, err error)
+	set(server, username, password string) error
+	remove(server string) error
+}
+
+// newCredentialStore returns the store with the given name: "netrc" or "keyring"
+func newCredentialStore(name, netrcPath string) (credentialStore, error) {
+	switch name {
+	case "", "netrc":
+		if netrcPath == "" {
+			return nil, errors.New("Could not determine netrc path")
+		}
+		return netrcStore{path: netrcPath}, nil
+	case "keyring":
+		return keyringStore{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown credential store %s", name)
+	}
+}
+
+// netrcStore keeps credentials in a netrc file
+type netrcStore struct {
+	path string
+}
+
+// get returns the credentials of the machine entry for server. The default entry is
+// ignored, since it usually holds credentials meant for other services.
+func (n netrcStore) get(server string) (string, string, error) {
+	_, entries, err := readNetrc(n.path)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, entry := range entries {
+		if entry.matches(server) {
+			return entry.login, entry.password, nil
+		}
+	}
+
+	return "", "", errNoCredentials
+}
+
+// set replaces the machine entry that get would return for server, or adds one,
+// leaving the rest of the file as it is
+func (n netrcStore) set(server, username, password string) error {
+	if strings.ContainsAny(username+password, " \t\r\n") {
+		return errors.New("Username and password cannot contain whitespace when using netrc")
+	}
+
+	data, entries, err := readNetrc(n.path)
+	if err != nil {
+		return err
+	}
+
+	entry := netrcEntry{machine: server, login: username, password: password}
+
+	for _, existing := range entries {
+		if existing.matches(server) {
+			entry.machine = existing.machine
+			return writeNetrc(n.path, data[:existing.start]+entry.String()+data[existing.end:])
+		}
+	}
+
+	// Keep the default entry last, since it matches every machine
+	for _, existing := range entries {
+		if existing.machine == "" {
+			return writeNetrc(n.path, data[:existing.start]+entry.String()+"\n"+data[existing.start:])
+		}
+	}
+
+	if data != "" && !strings.HasSuffix(data, "\n") {
+		data += "\n"
+	}
+
+	return writeNetrc(n.path, data+entry.String()+"\n")
+}
+
+// remove deletes every machine entry that get could return for server,
+// leaving the rest of the file as it is
+func (n netrcStore) remove(server string) error {
+	data, entries, err := readNetrc(n.path)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].matches(server) {
+			continue
+		}
+
+		// Take the line break with the entry if nothing else follows it on the line
+		end := entries[i].end
+		rest := strings.TrimLeft(data[end:], " \t")
+		if strings.HasPrefix(rest, "\n") {
+			end = len(data) - len(rest) + 1
+		}
+
+		data = data[:entries[i].start] + data[end:]
+		removed = true
+	}
+
+	if !removed {
+		return errNoCredentials
+	}
+
+	return writeNetrc(n.path, data)
+}
+
+// keyringStore keeps credentials in the system keyring (the Secret Service on Linux)
+type keyringStore struct{}
+
+type keyringEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (keyringStore) get(server string) (string, string, error) {
+	secret, err := keyring.Get(keyringService, server)
+	if err == keyring.ErrNotFound {
+		return "", "", errNoCredentials
+	} else if err != nil {
+		return "", "", err
+	}
+
+	var entry keyringEntry
+	err = json.Unmarshal([]byte(secret), &entry)
+	if err != nil {
+		return "", "", err
+	}
+
+	return entry.Username, entry.Password, nil
+}
+
+func (keyringStore) set(server, username, password string) error {
+	secret, err := json.Marshal(keyringEntry{username, password})
+	if err != nil {
+		return err
+	}
+
+	return keyring.Set(keyringService, server, string(secret))
+}
+
+func (keyringStore) remove(server string) error {
+	err := keyring.Delete(keyringService, server)
+	if err == keyring.ErrNotFound {
+		return errNoCredentials
+	}
+
+	return err
+}
+
+// hostOnly strips the port from a server address, if present
+func hostOnly(server string) string {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return server
+	}
+
+	return host
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func deleteAPIKeyCmd() *cobra.Command {
+	deleteAPIKeyCmd := cobra.Command{
+		Use:           "delete-api-key ID",
+		Short:         "Revoke an API key on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No key specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			resp, err := doRequest("DELETE", "/keys/"+args[0], nil, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("API key deleted")
+			return nil
+		},
+	}
+
+	return &deleteAPIKeyCmd
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func deleteInterfaceCmd() *cobra.Command {
+	deleteInterfaceCmd := cobra.Command{
+		Use:           "delete-interface IFACENAME",
+		Short:         "Delete an unused WireGuard interface from the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No interface specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			resp, err := doRequest("DELETE", "/interfaces/"+args[0], nil, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("Interface deleted")
+			return nil
+		},
+	}
+
+	return &deleteInterfaceCmd
+}
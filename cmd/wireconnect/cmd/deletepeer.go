@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+func deletePeerCmd() *cobra.Command {
+	deletePeerCmd := cobra.Command{
+		Use:           "delete-peer PEERNAME",
+		Short:         "Delete a peer configuration from the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No peer specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			query := url.Values{}
+			username, _ := cmd.Flags().GetString("username")
+			if username != "" {
+				query.Set("user", username)
+			}
+
+			resp, err := doRequest("DELETE", "/peers/"+args[0], query, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("Peer deleted")
+			return nil
+		},
+	}
+
+	deletePeerCmd.Flags().String("username", "", "Username of peer configuration's owner (Default: current user)")
+
+	return &deletePeerCmd
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func deleteUserCmd() *cobra.Command {
+	deleteUserCmd := cobra.Command{
+		Use:           "delete-user USERNAME",
+		Short:         "Delete a user and all of their peer configurations",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No user specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			resp, err := doRequest("DELETE", "/users/"+args[0], nil, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("User deleted")
+			return nil
+		},
+	}
+
+	return &deleteUserCmd
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func disableTOTPCmd() *cobra.Command {
+	disableTOTPCmd := cobra.Command{
+		Use:           "disable-totp",
+		Short:         "Stop requiring a one-time code for a user",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				path = "/users/me/totp"
+				body interface{}
+			)
+
+			if username, _ := cmd.Flags().GetString("username"); username != "" {
+				path = "/users/" + username + "/totp"
+			} else {
+				// Codes are single-use, so this must not be the code used to log in
+				code, err := promptOTP()
+				if err != nil {
+					return err
+				}
+				body = &wireconnect.DisableTOTPRequest{Code: code}
+			}
+
+			resp, err := doRequest("DELETE", path, nil, body)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("TOTP disabled")
+			return nil
+		},
+	}
+
+	disableTOTPCmd.Flags().String("username", "", "User to disable TOTP for, e.g. after losing their device (administrators only; Default: current user)")
+
+	return &disableTOTPCmd
+}
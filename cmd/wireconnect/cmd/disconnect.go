@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func disconnectCmd() *cobra.Command {
+	disconnectCmd := cobra.Command{
+		Use:           "disconnect [PEERNAME]",
+		Short:         "Disconnect from wireconnect VPN server and delete the local interface",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peername, err := peerArg(args)
+			if err != nil {
+				return err
+			}
+
+			serverErr := requestDisconnection(peername)
+
+			// Tear down the local interface even if the server could not be reached
+			err = deleteLink()
+			if err != nil {
+				return err
+			}
+
+			if serverErr != nil {
+				return serverErr
+			}
+
+			fmt.Println("Disconnected")
+			return nil
+		},
+	}
+
+	return &disconnectCmd
+}
+
+// requestDisconnection asks the server to remove the peer
+func requestDisconnection(peername string) error {
+	msg := wireconnect.DisconnectionRequest{
+		PeerName: peername,
+	}
+
+	resp, err := doRequest("POST", "/disconnect", nil, msg)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errorReply(resp)
+	}
+
+	return nil
+}
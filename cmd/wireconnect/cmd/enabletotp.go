@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func enableTOTPCmd() *cobra.Command {
+	enableTOTPCmd := cobra.Command{
+		Use:           "enable-totp",
+		Short:         "Require a one-time code in addition to your password",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := doRequest("POST", "/users/me/totp", nil, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			var enrollment wireconnect.TOTPEnrollment
+			err = json.NewDecoder(resp.Body).Decode(&enrollment)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("Add this secret to your authenticator app:")
+			fmt.Println(enrollment.Secret)
+			fmt.Println(enrollment.URL)
+			fmt.Println()
+
+			fmt.Print("Enter the code shown by the app: ")
+			reader := bufio.NewReader(os.Stdin)
+			code, err := reader.ReadString('\n')
+			if err != nil {
+				return err
+			}
+
+			msg := &wireconnect.VerifyTOTPRequest{
+				Code: strings.TrimSpace(code),
+			}
+
+			verifyResp, err := doRequest("POST", "/users/me/totp/verify", nil, msg)
+			if err != nil {
+				return err
+			}
+			defer verifyResp.Body.Close()
+
+			if verifyResp.StatusCode != http.StatusOK {
+				return errorReply(verifyResp)
+			}
+
+			var activation wireconnect.TOTPActivation
+			err = json.NewDecoder(verifyResp.Body).Decode(&activation)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("TOTP enabled. Store these recovery codes somewhere safe; each can be used once in place of a code:")
+			for _, code := range activation.RecoveryCodes {
+				fmt.Println(code)
+			}
+
+			return nil
+		},
+	}
+
+	return &enableTOTPCmd
+}
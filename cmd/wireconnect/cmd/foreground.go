@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sector-f/wireconnect"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// runForeground keeps the connection alive until SIGINT or SIGTERM is received.
+// The server is sent a heartbeat every interval; if it no longer knows the peer's
+// key or its own public key has changed, the client reconnects with a fresh key.
+func runForeground(peername string, privKey wgtypes.Key, reply *wireconnect.ConnectionReply, interval, keepalive time.Duration) error {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Connected as peer %s\n", peername)
+
+	for {
+		select {
+		case sig := <-sigChan:
+			log.Printf("Caught %v; disconnecting\n", sig)
+
+			serverErr := requestDisconnection(peername)
+			if serverErr != nil {
+				log.Printf("Failed to disconnect from server: %v\n", serverErr)
+			}
+
+			return deleteLink()
+		case <-ticker.C:
+			hb, err := sendHeartbeat(peername, privKey.PublicKey())
+			if err != nil {
+				// The server may just be restarting; keep the tunnel up and try again later
+				log.Printf("Heartbeat failed: %v\n", err)
+				continue
+			}
+
+			if hb.Active && hb.ServerPublicKey == reply.PublicKey {
+				continue
+			}
+
+			if hb.ServerPublicKey != reply.PublicKey {
+				log.Println("Server public key has changed; reconnecting")
+			} else {
+				log.Println("Server no longer knows this peer; reconnecting")
+			}
+
+			err = deleteLink()
+			if err != nil {
+				log.Printf("Failed to delete interface: %v\n", err)
+				continue
+			}
+
+			newKey, newReply, err := connect(peername, keepalive)
+			if err != nil {
+				log.Printf("Failed to reconnect: %v\n", err)
+				continue
+			}
+
+			privKey, reply = newKey, newReply
+			log.Println("Reconnected")
+		}
+	}
+}
+
+func sendHeartbeat(peername string, pubKey wgtypes.Key) (*wireconnect.HeartbeatReply, error) {
+	msg := wireconnect.HeartbeatRequest{
+		PeerName:  peername,
+		PublicKey: pubKey.String(),
+	}
+
+	resp, err := doRequest("POST", "/heartbeat", nil, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errorReply(resp)
+	}
+
+	var reply wireconnect.HeartbeatReply
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func listAPIKeysCmd() *cobra.Command {
+	listAPIKeysCmd := cobra.Command{
+		Use:           "list-api-keys",
+		Short:         "List API keys on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			if all, _ := cmd.Flags().GetBool("all"); all {
+				query.Set("all", "true")
+			}
+
+			resp, err := doRequest("GET", "/keys", query, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			var keys []wireconnect.APIKey
+			err = json.NewDecoder(resp.Body).Decode(&keys)
+			if err != nil {
+				return err
+			}
+
+			for _, key := range keys {
+				scopes := "all"
+				if len(key.Scopes) > 0 {
+					scopes = strings.Join(key.Scopes, ",")
+				}
+
+				fmt.Printf("%s %s %s [%s] (created %s)\n", key.ID, key.User, key.Name, scopes, key.CreatedAt.Format(time.RFC3339))
+			}
+
+			return nil
+		},
+	}
+
+	listAPIKeysCmd.Flags().Bool("all", false, "List the keys of every user (administrators only)")
+
+	return &listAPIKeysCmd
+}
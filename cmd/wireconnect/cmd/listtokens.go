@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func listTokensCmd() *cobra.Command {
+	listTokensCmd := cobra.Command{
+		Use:           "list-tokens",
+		Short:         "List login sessions on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			if username, _ := cmd.Flags().GetString("username"); username != "" {
+				query.Set("user", username)
+			}
+
+			resp, err := doRequest("GET", "/tokens", query, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			var tokens []wireconnect.Token
+			err = json.NewDecoder(resp.Body).Decode(&tokens)
+			if err != nil {
+				return err
+			}
+
+			for _, token := range tokens {
+				fmt.Printf(
+					"%s %s (issued %s, expires %s)\n",
+					token.ID,
+					token.User,
+					token.IssuedAt.Format(time.RFC3339),
+					token.RefreshExpiry.Format(time.RFC3339),
+				)
+			}
+
+			return nil
+		},
+	}
+
+	listTokensCmd.Flags().String("username", "", "Only list tokens belonging to this user")
+
+	return &listTokensCmd
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func listUsersCmd() *cobra.Command {
+	listUsersCmd := cobra.Command{
+		Use:           "list-users",
+		Short:         "List users on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := doRequest("GET", "/users", nil, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			var users []wireconnect.User
+			err = json.NewDecoder(resp.Body).Decode(&users)
+			if err != nil {
+				return err
+			}
+
+			for _, user := range users {
+				line := user.Name
+				if user.IsAdmin {
+					line += " (admin)"
+				}
+				if user.MaxSessionLifetime > 0 {
+					line += fmt.Sprintf(" [max session: %v]", time.Duration(user.MaxSessionLifetime)*time.Second)
+				}
+
+				fmt.Println(line)
+			}
+
+			return nil
+		},
+	}
+
+	return &listUsersCmd
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func loginCmd() *cobra.Command {
+	loginCmd := cobra.Command{
+		Use:           "login",
+		Short:         "Check and store credentials for the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Annotations:   map[string]string{authAnnotation: authPrompt},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			password, err := getPassword()
+			if err != nil {
+				return err
+			}
+
+			// Discard any cached token so that the password is checked
+			err = Tokens.remove(Server)
+			if err != nil {
+				return err
+			}
+
+			resp, err := doRequest("GET", "/peers", nil, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			err = Store.set(Server, Username, password)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Stored credentials for %s\n", Server)
+			return nil
+		},
+	}
+
+	return &loginCmd
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func logoutCmd() *cobra.Command {
+	logoutCmd := cobra.Command{
+		Use:           "logout",
+		Short:         "Remove stored credentials for the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Annotations:   map[string]string{authAnnotation: authNone},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Revoking the token on the server is best-effort; it expires on its own
+			if cached := Tokens.get(Server, ""); cached != nil {
+				revokeToken(cached)
+			}
+
+			err := Tokens.remove(Server)
+			if err != nil {
+				return err
+			}
+
+			err = Store.remove(Server)
+			switch err {
+			case nil:
+				fmt.Printf("Removed credentials for %s\n", Server)
+			case errNoCredentials:
+				fmt.Printf("No stored credentials for %s\n", Server)
+			default:
+				return err
+			}
+
+			return nil
+		},
+	}
+
+	return &logoutCmd
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func modifyInterfaceCmd() *cobra.Command {
+	modifyInterfaceCmd := cobra.Command{
+		Use:           "modify-interface IFACENAME",
+		Short:         "Modify a WireGuard interface on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No interface specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			msg := &wireconnect.ModifyInterfaceRequest{}
+			msg.Addresses, _ = cmd.Flags().GetStringSlice("address")
+
+			if cmd.Flags().Changed("create-on-startup") {
+				createOnStartup, _ := cmd.Flags().GetBool("create-on-startup")
+				msg.CreateOnStartup = &createOnStartup
+			}
+
+			if cmd.Flags().Changed("listen-port") {
+				listenPort, _ := cmd.Flags().GetInt("listen-port")
+				msg.ListenPort = &listenPort
+			}
+
+			if len(msg.Addresses) == 0 && msg.CreateOnStartup == nil && msg.ListenPort == nil {
+				return errors.New("Nothing to modify")
+			}
+
+			resp, err := doRequest("PATCH", "/interfaces/"+args[0], nil, msg)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("Interface modified")
+			return nil
+		},
+	}
+
+	modifyInterfaceCmd.Flags().StringSliceP("address", "a", nil, "Replace interface addresses with this comma-separated list (CIDR notation)")
+	modifyInterfaceCmd.Flags().IntP("listen-port", "p", 0, "UDP port for the interface to listen on (0: chosen by the kernel)")
+	modifyInterfaceCmd.Flags().Bool("create-on-startup", true, "Create the interface when the server starts")
+
+	return &modifyInterfaceCmd
+}
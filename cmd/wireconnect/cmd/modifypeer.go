@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func modifyPeerCmd() *cobra.Command {
+	modifyPeerCmd := cobra.Command{
+		Use:           "modify-peer PEERNAME",
+		Short:         "Modify a peer configuration on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No peer specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			address, _ := cmd.Flags().GetString("address")
+			endpointAddress, _ := cmd.Flags().GetString("endpoint-address")
+			serverInterface, _ := cmd.Flags().GetString("server-interface")
+
+			if address == "" && endpointAddress == "" && serverInterface == "" {
+				return errors.New("Nothing to modify")
+			}
+
+			msg := &wireconnect.ModifyPeerRequest{
+				Address:         address,
+				EndpointAddress: endpointAddress,
+				ServerInterface: serverInterface,
+			}
+
+			query := url.Values{}
+			username, _ := cmd.Flags().GetString("username")
+			if username != "" {
+				query.Set("user", username)
+			}
+
+			resp, err := doRequest("PATCH", "/peers/"+args[0], query, msg)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("Peer modified")
+			return nil
+		},
+	}
+
+	modifyPeerCmd.Flags().String("username", "", "Username of peer configuration's owner (Default: current user)")
+	modifyPeerCmd.Flags().StringP("address", "a", "", "Peer's new IPv4 and/or IPv6 WireGuard addresses, comma-separated")
+	modifyPeerCmd.Flags().StringP("endpoint-address", "e", "", "New endpoint address for peer to connect to")
+	modifyPeerCmd.Flags().StringP("server-interface", "i", "", "New WireGuard interface on the server")
+
+	return &modifyPeerCmd
+}
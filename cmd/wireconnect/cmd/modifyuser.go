@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func modifyUserCmd() *cobra.Command {
+	modifyUserCmd := cobra.Command{
+		Use:           "modify-user USERNAME",
+		Short:         "Modify a user on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No user specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			msg := &wireconnect.ModifyUserRequest{}
+
+			if cmd.Flags().Changed("admin") {
+				isAdmin, _ := cmd.Flags().GetBool("admin")
+				msg.IsAdmin = &isAdmin
+			}
+
+			if cmd.Flags().Changed("max-session-lifetime") {
+				lifetime, _ := cmd.Flags().GetDuration("max-session-lifetime")
+				seconds := int(lifetime.Seconds())
+				msg.MaxSessionLifetime = &seconds
+			}
+
+			if msg.IsAdmin == nil && msg.MaxSessionLifetime == nil {
+				return errors.New("Nothing to modify")
+			}
+
+			resp, err := doRequest("PATCH", "/users/"+args[0], nil, msg)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("User modified")
+			return nil
+		},
+	}
+
+	modifyUserCmd.Flags().Bool("admin", false, "Grant (--admin) or revoke (--admin=false) administrator privileges")
+	modifyUserCmd.Flags().Duration("max-session-lifetime", 0, "Disconnect the user's peers after this long (0 for unlimited)")
+
+	return &modifyUserCmd
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcEntry is a machine (or default) entry in a netrc file
+type netrcEntry struct {
+	machine  string // Empty for the default entry
+	login    string
+	password string
+	account  string
+
+	// Location of the entry in the file, so that it can be replaced or removed
+	// without disturbing comments, macros and other entries
+	start int
+	end   int
+}
+
+// String formats the entry as a single line, without a trailing newline
+func (e netrcEntry) String() string {
+	var b strings.Builder
+
+	if e.machine == "" {
+		b.WriteString("default")
+	} else {
+		fmt.Fprintf(&b, "machine %s", e.machine)
+	}
+
+	if e.login != "" {
+		fmt.Fprintf(&b, " login %s", e.login)
+	}
+	if e.password != "" {
+		fmt.Fprintf(&b, " password %s", e.password)
+	}
+	if e.account != "" {
+		fmt.Fprintf(&b, " account %s", e.account)
+	}
+
+	return b.String()
+}
+
+// matches reports whether the entry holds credentials for server, which is
+// named either with or without its port
+func (e netrcEntry) matches(server string) bool {
+	return e.machine != "" && (e.machine == server || e.machine == hostOnly(server))
+}
+
+type netrcToken struct {
+	text  string
+	start int
+	end   int
+}
+
+// defaultNetrcPath returns $NETRC if set, or ~/.netrc
+func defaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".netrc")
+}
+
+// readNetrc returns the contents of the netrc file at path along with its entries.
+// A missing file yields no entries.
+func readNetrc(path string) (string, []netrcEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, nil
+		}
+		return "", nil, err
+	}
+
+	return string(data), parseNetrc(string(data)), nil
+}
+
+// parseNetrc returns the machine and default entries in data.
+// Tokens that are not understood are ignored, since the file is shared with other programs.
+func parseNetrc(data string) []netrcEntry {
+	entries := []netrcEntry{}
+	var current *netrcEntry
+
+	tokens := netrcTokens(data)
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i].text {
+		case "machine":
+			if i+1 >= len(tokens) {
+				return entries
+			}
+			i++
+			entries = append(entries, netrcEntry{machine: tokens[i].text, start: tokens[i-1].start, end: tokens[i].end})
+			current = &entries[len(entries)-1]
+		case "default":
+			entries = append(entries, netrcEntry{start: tokens[i].start, end: tokens[i].end})
+			current = &entries[len(entries)-1]
+		case "macdef":
+			// Macros end the current entry; netrcTokens has already skipped the body
+			current = nil
+			i++
+		case "login", "password", "account":
+			if i+1 >= len(tokens) {
+				return entries
+			}
+			key := tokens[i].text
+			i++
+			if current == nil {
+				continue
+			}
+
+			switch key {
+			case "login":
+				current.login = tokens[i].text
+			case "password":
+				current.password = tokens[i].text
+			case "account":
+				current.account = tokens[i].text
+			}
+			current.end = tokens[i].end
+		}
+	}
+
+	return entries
+}
+
+// netrcTokens splits data into whitespace-separated tokens, skipping comments
+// and the bodies of macro definitions
+func netrcTokens(data string) []netrcToken {
+	tokens := []netrcToken{}
+
+	for i := 0; i < len(data); {
+		switch data[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+			continue
+		case '#':
+			// Comments run to the end of the line
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			continue
+		}
+
+		start := i
+		for i < len(data) && !strings.ContainsRune(" \t\r\n", rune(data[i])) {
+			i++
+		}
+		tokens = append(tokens, netrcToken{text: data[start:i], start: start, end: i})
+
+		if data[start:i] != "macdef" {
+			continue
+		}
+
+		// The macro's name is on the same line, and its body runs until the next empty line
+		lineEnd := strings.IndexByte(data[i:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(data) - i
+		}
+		name := strings.TrimSpace(data[i : i+lineEnd])
+		nameStart := i + strings.Index(data[i:], name)
+		tokens = append(tokens, netrcToken{text: name, start: nameStart, end: nameStart + len(name)})
+
+		i += lineEnd
+		bodyEnd := strings.Index(data[i:], "\n\n")
+		if bodyEnd < 0 {
+			i = len(data)
+		} else {
+			i += bodyEnd + 2
+		}
+	}
+
+	return tokens
+}
+
+// writeNetrc replaces the netrc file at path with data
+func writeNetrc(path, data string) error {
+	return ioutil.WriteFile(path, []byte(data), 0600)
+}
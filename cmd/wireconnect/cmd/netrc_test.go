@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []netrcEntry
+	}{
+		{
+			name: "empty",
+			data: "",
+			want: []netrcEntry{},
+		},
+		{
+			name: "single line",
+			data: "machine example.com login alice password secret\n",
+			want: []netrcEntry{{machine: "example.com", login: "alice", password: "secret"}},
+		},
+		{
+			name: "multiple lines",
+			data: "machine example.com\n\tlogin alice\n\tpassword secret\n\taccount acct\n",
+			want: []netrcEntry{{machine: "example.com", login: "alice", password: "secret", account: "acct"}},
+		},
+		{
+			name: "comments",
+			data: "# machine commented.com login mallory\nmachine example.com login alice # password ignored\npassword secret\n",
+			want: []netrcEntry{{machine: "example.com", login: "alice", password: "secret"}},
+		},
+		{
+			name: "macro body is skipped",
+			data: "machine example.com login alice password secret\n" +
+				"macdef init\nmachine evil.com login mallory password stolen\ncd /pub\n\n" +
+				"machine other.com login bob password hunter2\n",
+			want: []netrcEntry{
+				{machine: "example.com", login: "alice", password: "secret"},
+				{machine: "other.com", login: "bob", password: "hunter2"},
+			},
+		},
+		{
+			name: "macro ends the current entry",
+			data: "machine example.com login alice\nmacdef init\ncd /pub\n\npassword orphaned\n",
+			want: []netrcEntry{{machine: "example.com", login: "alice"}},
+		},
+		{
+			name: "macro at end of file",
+			data: "machine example.com login alice\nmacdef init\nmachine evil.com",
+			want: []netrcEntry{{machine: "example.com", login: "alice"}},
+		},
+		{
+			name: "unknown tokens are ignored",
+			data: "machine example.com port 21 login alice password secret\n",
+			want: []netrcEntry{{machine: "example.com", login: "alice", password: "secret"}},
+		},
+		{
+			name: "default entry",
+			data: "machine example.com login alice\ndefault login anonymous password guest\n",
+			want: []netrcEntry{
+				{machine: "example.com", login: "alice"},
+				{login: "anonymous", password: "guest"},
+			},
+		},
+		{
+			name: "truncated",
+			data: "machine example.com login",
+			want: []netrcEntry{{machine: "example.com"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseNetrc(test.data)
+
+			// Offsets are checked by the round trip tests
+			for i := range got {
+				got[i].start, got[i].end = 0, 0
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseNetrc() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// Comments, macros and the default entry must survive changes made by wireconnect
+const sharedNetrc = `# Shared with ftp and curl
+machine ftp.example.com login ftpuser password ftppass
+macdef init
+cd /pub
+binary
+
+machine vpn.example.com login alice password old
+default login anonymous password guest
+`
+
+func TestNetrcStore(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		change func(n netrcStore) error
+		want   string
+	}{
+		{
+			name: "replace",
+			data: sharedNetrc,
+			change: func(n netrcStore) error {
+				return n.set("vpn.example.com", "alice", "new")
+			},
+			want: `# Shared with ftp and curl
+machine ftp.example.com login ftpuser password ftppass
+macdef init
+cd /pub
+binary
+
+machine vpn.example.com login alice password new
+default login anonymous password guest
+`,
+		},
+		{
+			name: "replace entry without port",
+			data: sharedNetrc,
+			change: func(n netrcStore) error {
+				return n.set("vpn.example.com:8900", "alice", "new")
+			},
+			want: `# Shared with ftp and curl
+machine ftp.example.com login ftpuser password ftppass
+macdef init
+cd /pub
+binary
+
+machine vpn.example.com login alice password new
+default login anonymous password guest
+`,
+		},
+		{
+			name: "add before default",
+			data: sharedNetrc,
+			change: func(n netrcStore) error {
+				return n.set("lab.example.com:8900", "bob", "pass")
+			},
+			want: `# Shared with ftp and curl
+machine ftp.example.com login ftpuser password ftppass
+macdef init
+cd /pub
+binary
+
+machine vpn.example.com login alice password old
+machine lab.example.com:8900 login bob password pass
+default login anonymous password guest
+`,
+		},
+		{
+			name: "add to file without trailing newline",
+			data: "machine ftp.example.com login ftpuser",
+			change: func(n netrcStore) error {
+				return n.set("vpn.example.com", "alice", "pass")
+			},
+			want: "machine ftp.example.com login ftpuser\nmachine vpn.example.com login alice password pass\n",
+		},
+		{
+			name: "add to missing file",
+			data: "",
+			change: func(n netrcStore) error {
+				return n.set("vpn.example.com", "alice", "pass")
+			},
+			want: "machine vpn.example.com login alice password pass\n",
+		},
+		{
+			name: "remove",
+			data: sharedNetrc,
+			change: func(n netrcStore) error {
+				return n.remove("vpn.example.com")
+			},
+			want: `# Shared with ftp and curl
+machine ftp.example.com login ftpuser password ftppass
+macdef init
+cd /pub
+binary
+
+default login anonymous password guest
+`,
+		},
+		{
+			name: "remove entry without port",
+			data: sharedNetrc,
+			change: func(n netrcStore) error {
+				return n.remove("vpn.example.com:8900")
+			},
+			want: `# Shared with ftp and curl
+machine ftp.example.com login ftpuser password ftppass
+macdef init
+cd /pub
+binary
+
+default login anonymous password guest
+`,
+		},
+		{
+			name: "remove multiline entry",
+			data: "machine vpn.example.com\n  login alice\n  password old\nmachine ftp.example.com login ftpuser\n",
+			change: func(n netrcStore) error {
+				return n.remove("vpn.example.com")
+			},
+			want: "machine ftp.example.com login ftpuser\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := netrcStore{path: filepath.Join(t.TempDir(), "netrc")}
+			if test.data != "" {
+				err := ioutil.WriteFile(n.path, []byte(test.data), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := test.change(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ioutil.ReadFile(n.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("netrc file is\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNetrcStoreGet(t *testing.T) {
+	n := netrcStore{path: filepath.Join(t.TempDir(), "netrc")}
+	err := ioutil.WriteFile(n.path, []byte(sharedNetrc), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		server   string
+		login    string
+		password string
+		err      error
+	}{
+		{"vpn.example.com", "alice", "old", nil},
+		{"vpn.example.com:8900", "alice", "old", nil},
+		{"other.example.com", "", "", errNoCredentials}, // Not the default entry
+	}
+
+	for _, test := range tests {
+		login, password, err := n.get(test.server)
+		if login != test.login || password != test.password || err != test.err {
+			t.Errorf("get(%s) = %q, %q, %v, want %q, %q, %v", test.server, login, password, err, test.login, test.password, test.err)
+		}
+	}
+
+	// Nothing to remove is reported rather than ignored
+	err = n.remove("other.example.com")
+	if err != errNoCredentials {
+		t.Errorf("remove(other.example.com) = %v, want %v", err, errNoCredentials)
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func passwdCmd() *cobra.Command {
+	passwdCmd := cobra.Command{
+		Use:           "passwd",
+		Short:         "Change your password on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !passwordKnown {
+				fmt.Print("Current password: ")
+				current, err := terminal.ReadPassword(int(syscall.Stdin))
+				if err != nil {
+					return err
+				}
+				fmt.Println()
+
+				Password = string(current)
+				passwordKnown = true
+			}
+
+			fmt.Print("New password: ")
+			pw, err := terminal.ReadPassword(int(syscall.Stdin))
+			if err != nil {
+				return err
+			}
+			fmt.Println()
+
+			fmt.Print("Confirm new password: ")
+			confirm, err := terminal.ReadPassword(int(syscall.Stdin))
+			if err != nil {
+				return err
+			}
+			fmt.Println()
+
+			if string(pw) != string(confirm) {
+				return errors.New("Passwords do not match")
+			}
+			if len(pw) == 0 {
+				return errors.New("Password cannot be empty")
+			}
+
+			msg := &wireconnect.ChangePasswordRequest{
+				CurrentPassword: Password,
+				Password:        string(pw),
+			}
+
+			resp, err := doRequest("PUT", "/users/me/password", nil, msg)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("Password changed")
+			return nil
+		},
+	}
+
+	return &passwdCmd
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/sector-f/wireconnect"
+)
+
+var errOTPRequired = errors.New("One-time code required")
+
+// doRequest sends an authenticated request to the wireconnect server.
+// If body is non-nil, it is sent as JSON.
+func doRequest(method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var jsonMsg []byte
+	if body != nil {
+		var err error
+		jsonMsg, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, usedToken, err := sendRequest(method, path, query, jsonMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token may have been revoked; log in again and retry once
+	if resp.StatusCode == http.StatusUnauthorized && usedToken {
+		resp.Body.Close()
+
+		err = Tokens.remove(Server)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, _, err = sendRequest(method, path, query, jsonMsg)
+	}
+
+	return resp, err
+}
+
+// sendRequest sends a single request and reports whether it was authenticated with a token
+func sendRequest(method, path string, query url.Values, jsonMsg []byte) (*http.Response, bool, error) {
+	var reqBody io.Reader
+	if jsonMsg != nil {
+		reqBody = bytes.NewBuffer(jsonMsg)
+	}
+
+	req, err := http.NewRequest(method, serverURL(path, query), reqBody)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if jsonMsg != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	if APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+APIKey)
+
+		resp, err := Client.Do(req)
+		return resp, false, err
+	}
+
+	token, err := accessToken()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		err = setLoginAuth(req)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	resp, err := Client.Do(req)
+	return resp, token != "", err
+}
+
+// setLoginAuth adds the user's password and one-time code to req.
+// The client certificate takes the place of the password if no username was given.
+func setLoginAuth(req *http.Request) error {
+	if !certOnly {
+		password, err := getPassword()
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(Username, password)
+	}
+
+	if OTP != "" {
+		req.Header.Set(wireconnect.OTPHeader, OTP)
+	}
+
+	return nil
+}
+
+func serverURL(path string, query url.Values) string {
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     Server,
+		Path:     path,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+// accessToken returns an access token for the server, refreshing the cached token or
+// logging in if necessary. An empty token means that the server does not issue tokens,
+// so Basic authentication should be used instead.
+func accessToken() (string, error) {
+	cached := Tokens.get(Server, Username)
+	if cached != nil && cached.accessValid() {
+		return cached.AccessToken, nil
+	}
+
+	// If refreshing fails, for example because the session was revoked, log in again
+	if cached != nil && cached.refreshValid() {
+		reply, err := tokenRequest("/login/refresh", func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer "+cached.RefreshToken)
+			return nil
+		})
+
+		if err == nil && reply != nil {
+			cached.AccessToken = reply.AccessToken
+			cached.AccessExpiry = reply.AccessExpiry
+			return reply.AccessToken, Tokens.set(Server, cached)
+		}
+	}
+
+	reply, err := tokenRequest("/login", setLoginAuth)
+	if err == errOTPRequired {
+		_, err = getOTP()
+		if err != nil {
+			return "", err
+		}
+
+		reply, err = tokenRequest("/login", setLoginAuth)
+	}
+	if err != nil || reply == nil {
+		return "", err
+	}
+
+	err = Tokens.set(Server, &cachedToken{Username: Username, LoginReply: *reply})
+	if err != nil {
+		return "", err
+	}
+
+	return reply.AccessToken, nil
+}
+
+// tokenRequest sends a POST request to one of the login endpoints.
+// A nil reply and error mean that the server does not support tokens.
+func tokenRequest(path string, setAuth func(*http.Request) error) (*wireconnect.LoginReply, error) {
+	req, err := http.NewRequest("POST", serverURL(path, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setAuth(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, nil
+	case http.StatusUnauthorized:
+		if resp.Header.Get(wireconnect.OTPHeader) == "required" {
+			return nil, errOTPRequired
+		}
+		return nil, errorReply(resp)
+	default:
+		return nil, errorReply(resp)
+	}
+
+	reply := &wireconnect.LoginReply{}
+	err = json.NewDecoder(resp.Body).Decode(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// revokeToken asks the server to end the token's session, ignoring any errors
+func revokeToken(token *cachedToken) {
+	if !token.refreshValid() {
+		return
+	}
+
+	req, err := http.NewRequest("POST", serverURL("/logout", nil), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token.RefreshToken)
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+// errorReply returns the error message sent by the server as an error.
+func errorReply(resp *http.Response) error {
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var reply string
+	err = json.Unmarshal(data, &reply)
+	if err != nil {
+		return fmt.Errorf("Received %v", resp.Status)
+	}
+
+	return fmt.Errorf("Received %v: %v", resp.Status, reply)
+}
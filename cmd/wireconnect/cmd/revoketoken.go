@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func revokeTokenCmd() *cobra.Command {
+	revokeTokenCmd := cobra.Command{
+		Use:           "revoke-token ID",
+		Short:         "Revoke a login session on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No token specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			resp, err := doRequest("DELETE", "/tokens/"+args[0], nil, nil)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			fmt.Println("Token revoked")
+			return nil
+		},
+	}
+
+	return &revokeTokenCmd
+}
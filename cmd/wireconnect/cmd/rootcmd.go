@@ -1,10 +1,11 @@
 package cmd
 
 import (
-	"crypto/tls"
+	"bufio"
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"strings"
 	"syscall"
 
@@ -13,12 +14,77 @@ import (
 )
 
 var (
-	Username string
-	Password string
-	Server   string
-	Client   *http.Client
+	Username    string
+	Password    string
+	Server      string
+	DefaultPeer string
+	Client      *http.Client
+	Store       credentialStore
+	Tokens      *tokenCache
+	APIKey      string
+
+	// Whether Password has been given or prompted for; see getPassword
+	passwordKnown bool
+
+	// One-time code sent along with the password or client certificate; see getOTP
+	OTP string
+
+	// Whether the client certificate takes the place of a username and password
+	certOnly bool
+)
+
+// Commands can set this annotation to change how credentials are obtained
+const authAnnotation = "wireconnect/auth"
+
+const (
+	authPrompt = "prompt" // Ignore stored credentials and prompt for a password
+	authNone   = "none"   // No credentials are needed
 )
 
+// getPassword returns the user's password, prompting for it if it is not yet known.
+// Prompting is delayed until needed because a cached token may make it unnecessary.
+func getPassword() (string, error) {
+	if passwordKnown {
+		return Password, nil
+	}
+
+	fmt.Print("Password: ")
+	pw, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return "", err
+	}
+	fmt.Println()
+
+	Password = string(pw)
+	passwordKnown = true
+
+	return Password, nil
+}
+
+// getOTP prompts for a TOTP or recovery code. Codes can only be used once,
+// so a new one is requested each time.
+func getOTP() (string, error) {
+	code, err := promptOTP()
+	if err != nil {
+		return "", err
+	}
+
+	OTP = code
+	return OTP, nil
+}
+
+// promptOTP reads a TOTP or recovery code from the terminal
+func promptOTP() (string, error) {
+	fmt.Print("One-time code: ")
+	reader := bufio.NewReader(os.Stdin)
+	code, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(code), nil
+}
+
 func Root() *cobra.Command {
 	rootCmd := cobra.Command{
 		Use:           "wireconnect -u USERNAME[:PASSWORD] -s SERVER[:IP] SUBCOMMAND [flags]",
@@ -26,11 +92,35 @@ func Root() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			configarg, err := cmd.Flags().GetString("config")
+			if err != nil {
+				return err
+			}
+
+			config, err := loadConfig(configarg, cmd.Flags().Changed("config"))
+			if err != nil {
+				return err
+			}
+
+			profilearg, err := cmd.Flags().GetString("profile")
+			if err != nil {
+				return err
+			}
+
+			prof, err := config.profile(profilearg)
+			if err != nil {
+				return err
+			}
+			DefaultPeer = prof.Peer
+
 			serverarg, err := cmd.Flags().GetString("server")
 			if err != nil {
 				return err
 			}
 
+			if serverarg == "" {
+				serverarg = prof.Server
+			}
 			if serverarg == "" {
 				return errors.New("Server not specified")
 			}
@@ -42,40 +132,162 @@ func Root() *cobra.Command {
 			}
 
 			if userarg == "" {
-				return errors.New("Username not specified")
+				userarg = prof.Username
+			}
+
+			storearg, err := cmd.Flags().GetString("credential-store")
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("credential-store") {
+				prof.CredentialStore = storearg
 			}
 
+			netrcarg, err := cmd.Flags().GetString("netrc-file")
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("netrc-file") || prof.NetrcFile == "" {
+				prof.NetrcFile = netrcarg
+			}
+
+			Store, err = newCredentialStore(prof.CredentialStore, prof.NetrcFile)
+			if err != nil {
+				return err
+			}
+
+			auth := cmd.Annotations[authAnnotation]
+
 			userpass := strings.SplitN(userarg, ":", 2)
+			username := userpass[0]
 			var password string
+			havePassword := len(userpass) == 2
 
-			if len(userpass) == 1 {
-				fmt.Print("Password: ")
-				pw, err := terminal.ReadPassword(int(syscall.Stdin))
-				if err != nil {
+			if havePassword {
+				password = userpass[1]
+			} else if auth == "" {
+				storedUser, storedPass, err := Store.get(Server)
+				if err != nil && err != errNoCredentials {
 					return err
 				}
-				fmt.Println()
-				password = string(pw)
-			} else {
-				password = userpass[1]
+
+				if err == nil && (username == "" || username == storedUser) {
+					username = storedUser
+					password = storedPass
+					havePassword = true
+				}
 			}
 
-			username := userpass[0]
+			Tokens, err = loadTokenCache(defaultTokenCachePath())
+			if err != nil {
+				return err
+			}
+
+			if username == "" && auth == "" {
+				if cached := Tokens.get(Server, ""); cached != nil {
+					username = cached.Username
+				}
+			}
+
+			APIKey, err = cmd.Flags().GetString("api-key")
+			if err != nil {
+				return err
+			}
+
+			certarg, err := cmd.Flags().GetString("client-cert")
+			if err != nil {
+				return err
+			}
+			if certarg != "" {
+				prof.ClientCert = certarg
+			}
+
+			keyarg, err := cmd.Flags().GetString("client-key")
+			if err != nil {
+				return err
+			}
+			if keyarg != "" {
+				prof.ClientKey = keyarg
+			}
+
+			// The server can determine the user from a client certificate
+			certOnly = username == "" && prof.ClientCert != ""
+
+			if auth != authNone && username == "" && APIKey == "" && !certOnly {
+				return errors.New("Username not specified")
+			}
 
 			Username = username
 			Password = password
+			passwordKnown = havePassword
+
+			OTP, err = cmd.Flags().GetString("otp")
+			if err != nil {
+				return err
+			}
+
+			// Users with TOTP enabled can set this to be asked up front rather than when
+			// the server requests a code
+			if OTP == "" && prof.TOTP && auth != authNone && APIKey == "" {
+				_, err = getOTP()
+				if err != nil {
+					return err
+				}
+			}
 
 			insecurearg, err := cmd.Flags().GetBool("insecure")
 			if err != nil {
 				return err
 			}
 
-			config := &tls.Config{
-				InsecureSkipVerify: insecurearg,
+			if cmd.Flags().Changed("insecure") {
+				prof.Insecure = insecurearg
+			}
+
+			caarg, err := cmd.Flags().GetString("ca-file")
+			if err != nil {
+				return err
+			}
+			if caarg != "" {
+				prof.CAFile = caarg
+			}
+
+			pinarg, err := cmd.Flags().GetString("pin-sha256")
+			if err != nil {
+				return err
+			}
+			if pinarg != "" {
+				prof.PinSHA256 = pinarg
+			}
+			if prof.PinSHA256 == "" {
+				prof.PinSHA256 = config.Pins[Server]
+			}
+
+			tofuarg, err := cmd.Flags().GetBool("trust-on-first-use")
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("trust-on-first-use") {
+				prof.TrustOnFirstUse = tofuarg
+			}
+
+			var verifier *pinVerifier
+			if prof.PinSHA256 != "" || prof.TrustOnFirstUse {
+				verifier = &pinVerifier{
+					server:     Server,
+					pin:        strings.TrimPrefix(prof.PinSHA256, "sha256//"),
+					firstUse:   prof.TrustOnFirstUse,
+					configPath: config.path,
+				}
+			}
+
+			tlsConfig, err := prof.tlsConfig(verifier)
+			if err != nil {
+				return err
 			}
 
 			transport := &http.Transport{
-				TLSClientConfig: config,
+				TLSClientConfig: tlsConfig,
 			}
 
 			Client = &http.Client{
@@ -89,9 +301,40 @@ func Root() *cobra.Command {
 	rootCmd.PersistentFlags().StringP("user", "u", "", "Specify username[:password]")
 	rootCmd.PersistentFlags().StringP("server", "s", "", "Specify server address[:port] (Default port: 8900)")
 	rootCmd.PersistentFlags().BoolP("insecure", "k", false, "Ignore insecure TLS connections")
+	rootCmd.PersistentFlags().String("ca-file", "", "Path to CA bundle used to verify the server's certificate")
+	rootCmd.PersistentFlags().String("pin-sha256", "", "Base64-encoded SHA-256 hash of the server's public key; the certificate chain is not checked unless --ca-file is given")
+	rootCmd.PersistentFlags().Bool("trust-on-first-use", false, "Pin the server's public key in the configuration file the first time it is seen")
+	rootCmd.PersistentFlags().String("config", defaultConfigPath(), "Path to client configuration file")
+	rootCmd.PersistentFlags().String("credential-store", "netrc", "Where to look up stored credentials: netrc or keyring")
+	rootCmd.PersistentFlags().String("netrc-file", defaultNetrcPath(), "Path to netrc file used for stored credentials")
+	rootCmd.PersistentFlags().String("api-key", os.Getenv("WIRECONNECT_API_KEY"), "Authenticate with an API key instead of a password (Default: $WIRECONNECT_API_KEY)")
+	rootCmd.PersistentFlags().String("client-cert", "", "Path to client certificate used to authenticate")
+	rootCmd.PersistentFlags().String("client-key", "", "Path to client certificate's private key")
+	rootCmd.PersistentFlags().String("otp", "", "TOTP or recovery code (prompted for when required)")
+	rootCmd.PersistentFlags().String("profile", "", "Name of server profile to use from the configuration file")
 
 	rootCmd.AddCommand(connectCmd())
+	rootCmd.AddCommand(disconnectCmd())
 	rootCmd.AddCommand(addPeerCmd())
+	rootCmd.AddCommand(deletePeerCmd())
+	rootCmd.AddCommand(modifyPeerCmd())
+	rootCmd.AddCommand(listUsersCmd())
+	rootCmd.AddCommand(deleteUserCmd())
+	rootCmd.AddCommand(modifyUserCmd())
+	rootCmd.AddCommand(passwdCmd())
+	rootCmd.AddCommand(addInterfaceCmd())
+	rootCmd.AddCommand(modifyInterfaceCmd())
+	rootCmd.AddCommand(deleteInterfaceCmd())
+	rootCmd.AddCommand(rotateKeyCmd())
+	rootCmd.AddCommand(loginCmd())
+	rootCmd.AddCommand(logoutCmd())
+	rootCmd.AddCommand(listTokensCmd())
+	rootCmd.AddCommand(revokeTokenCmd())
+	rootCmd.AddCommand(createAPIKeyCmd())
+	rootCmd.AddCommand(listAPIKeysCmd())
+	rootCmd.AddCommand(deleteAPIKeyCmd())
+	rootCmd.AddCommand(enableTOTPCmd())
+	rootCmd.AddCommand(disableTOTPCmd())
 
 	return &rootCmd
 }
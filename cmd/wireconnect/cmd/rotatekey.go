@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sector-f/wireconnect"
+	"github.com/spf13/cobra"
+)
+
+func rotateKeyCmd() *cobra.Command {
+	rotateKeyCmd := cobra.Command{
+		Use:           "rotate-key IFACENAME",
+		Short:         "Replace the private key of a WireGuard interface on the wireconnect VPN server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("No interface specified")
+			} else if len(args) > 1 {
+				return errors.New("Too many arguments specified")
+			}
+
+			gracePeriod, _ := cmd.Flags().GetDuration("grace-period")
+			if gracePeriod < 0 {
+				return errors.New("Grace period cannot be negative")
+			}
+
+			msg := &wireconnect.RotateKeyRequest{
+				GracePeriod: int(gracePeriod.Seconds()),
+			}
+
+			resp, err := doRequest("POST", "/interfaces/"+args[0]+"/rotate-key", nil, msg)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return errorReply(resp)
+			}
+
+			var reply wireconnect.KeyRotationReply
+			err = json.NewDecoder(resp.Body).Decode(&reply)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("New public key %s takes effect at %v\n", reply.PublicKey, reply.RotationTime)
+			return nil
+		},
+	}
+
+	rotateKeyCmd.Flags().Duration("grace-period", 0, "Time until the new key replaces the current one")
+
+	return &rotateKeyCmd
+}
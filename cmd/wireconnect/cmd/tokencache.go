@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sector-f/wireconnect"
+)
+
+// cachedToken is a login session with a server
+type cachedToken struct {
+	Username string `json:"username"`
+	wireconnect.LoginReply
+}
+
+// accessValid reports whether the access token can still be used
+func (t *cachedToken) accessValid() bool {
+	return t.AccessToken != "" && time.Now().Add(30*time.Second).Before(t.AccessExpiry)
+}
+
+// refreshValid reports whether the refresh token can still be used
+func (t *cachedToken) refreshValid() bool {
+	return t.RefreshToken != "" && time.Now().Add(30*time.Second).Before(t.RefreshExpiry)
+}
+
+// tokenCache stores tokens on disk, keyed by server address
+type tokenCache struct {
+	path   string
+	tokens map[string]*cachedToken
+}
+
+// defaultTokenCachePath returns the file used to cache tokens
+func defaultTokenCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "wireconnect", "tokens.json")
+}
+
+// loadTokenCache reads the token cache at path. A missing file yields an empty cache,
+// and an empty path yields a cache that is never written.
+func loadTokenCache(path string) (*tokenCache, error) {
+	cache := &tokenCache{
+		path:   path,
+		tokens: make(map[string]*cachedToken),
+	}
+
+	if path == "" {
+		return cache, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &cache.tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// get returns the cached token for server if it belongs to username and can still be used.
+// An empty username matches any user.
+func (c *tokenCache) get(server, username string) *cachedToken {
+	token, ok := c.tokens[server]
+	if !ok {
+		return nil
+	}
+
+	if username != "" && token.Username != username {
+		return nil
+	}
+
+	if !token.accessValid() && !token.refreshValid() {
+		return nil
+	}
+
+	return token
+}
+
+func (c *tokenCache) set(server string, token *cachedToken) error {
+	c.tokens[server] = token
+	return c.save()
+}
+
+func (c *tokenCache) remove(server string) error {
+	if _, ok := c.tokens[server]; !ok {
+		return nil
+	}
+
+	delete(c.tokens, server)
+	return c.save()
+}
+
+func (c *tokenCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.path, data, 0600)
+}
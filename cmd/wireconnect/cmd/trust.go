@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// spkiPin returns the base64-encoded SHA-256 hash of a certificate's public key,
+// in the same format as HPKP and curl's --pinnedpubkey
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// pinVerifier checks the server's public key against a pin. If there is no pin and
+// trust on first use is enabled, the first key seen is pinned and saved.
+type pinVerifier struct {
+	server     string
+	pin        string
+	firstUse   bool
+	configPath string
+	mu         sync.Mutex
+}
+
+// apply sets up config to use the verifier. Unless a CA bundle was given, the
+// certificate chain is not checked, since the pin identifies the server.
+func (v *pinVerifier) apply(config *tls.Config) {
+	if config.RootCAs == nil {
+		config.InsecureSkipVerify = true
+	}
+
+	config.VerifyPeerCertificate = v.verify
+}
+
+func (v *pinVerifier) verify(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("Server did not send a certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+
+	got := spkiPin(leaf)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.pin == "" {
+		if !v.firstUse {
+			return nil
+		}
+
+		fmt.Fprintf(os.Stderr, "Trusting %s on first use; pinned public key sha256 %s\n", v.server, got)
+		v.pin = got
+		return savePin(v.configPath, v.server, got)
+	}
+
+	if got != v.pin {
+		return fmt.Errorf(`
+@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+@    WARNING: SERVER PUBLIC KEY DOES NOT MATCH THE PIN!    @
+@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+Someone could be intercepting your connection to %s.
+Expected public key sha256: %s
+Received public key sha256: %s
+If the server's key was changed on purpose, update its pin in %s`,
+			v.server, v.pin, got, v.configPath)
+	}
+
+	return nil
+}
+
+// savePin records a server's pin in the [pins] table of the configuration file,
+// leaving the rest of the file untouched
+func savePin(path, server, pin string) error {
+	if path == "" {
+		return errors.New("Cannot save pin: no configuration file")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	entry := fmt.Sprintf("%s = %s", strconv.Quote(server), strconv.Quote(pin))
+
+	lines := strings.Split(string(data), "\n")
+	inserted := false
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "[pins]" {
+			lines = append(lines[:i+1], append([]string{entry}, lines[i+1:]...)...)
+			inserted = true
+			break
+		}
+	}
+
+	if !inserted {
+		if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "[pins]", entry, "")
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600)
+}
@@ -6,14 +6,20 @@ import (
 	"math/bits"
 	"net"
 	"net/http"
+	"time"
 )
 
 var (
 	DatabaseError      = ErrorResponse{http.StatusInternalServerError, "Database error"}
 	ParseJsonError     = ErrorResponse{http.StatusBadRequest, "Improperly-formed request body"}
 	IncompleteReqError = ErrorResponse{http.StatusBadRequest, "Incomplete request"}
+	InvalidPortError   = ErrorResponse{http.StatusBadRequest, "Invalid port number"}
 )
 
+// Header carrying a TOTP or recovery code when authenticating with a password.
+// The server sets it to "required" in 401 replies if a code is needed.
+const OTPHeader = "X-Wireconnect-OTP"
+
 type SuccessResponse struct {
 	Status  int
 	Payload interface{}
@@ -29,8 +35,13 @@ func (e ErrorResponse) Error() string {
 }
 
 type ServerInterface struct {
-	Name      string    `json:"name"`
-	Addresses []Address `json:"addresses"` // TODO: Maybe change this to []string?
+	Name            string     `json:"name"`
+	Addresses       []Address  `json:"addresses"` // TODO: Maybe change this to []string?
+	CreateOnStartup bool       `json:"create_on_startup"`
+	ListenPort      int        `json:"listen_port"`
+	PublicKey       string     `json:"public_key,omitempty"`
+	NextPublicKey   string     `json:"next_public_key,omitempty"`
+	KeyRotationTime *time.Time `json:"key_rotation_time,omitempty"`
 }
 
 func (s ServerInterface) MarshalJSON() ([]byte, error) {
@@ -40,23 +51,61 @@ func (s ServerInterface) MarshalJSON() ([]byte, error) {
 	}
 
 	retVal := struct {
-		Name      string   `json:"name"`
-		Addresses []string `json:"addresses"`
-	}{s.Name, retAddr}
+		Name            string     `json:"name"`
+		Addresses       []string   `json:"addresses"`
+		CreateOnStartup bool       `json:"create_on_startup"`
+		ListenPort      int        `json:"listen_port"`
+		PublicKey       string     `json:"public_key,omitempty"`
+		NextPublicKey   string     `json:"next_public_key,omitempty"`
+		KeyRotationTime *time.Time `json:"key_rotation_time,omitempty"`
+	}{s.Name, retAddr, s.CreateOnStartup, s.ListenPort, s.PublicKey, s.NextPublicKey, s.KeyRotationTime}
 
 	return json.Marshal(&retVal)
 }
 
+type CreateInterfaceRequest struct {
+	Name            string   `json:"name"`
+	Addresses       []string `json:"addresses"`
+	CreateOnStartup *bool    `json:"create_on_startup,omitempty"`
+	ListenPort      int      `json:"listen_port,omitempty"`
+}
+
+type ModifyInterfaceRequest struct {
+	Addresses       []string `json:"addresses,omitempty"`
+	CreateOnStartup *bool    `json:"create_on_startup,omitempty"`
+	ListenPort      *int     `json:"listen_port,omitempty"`
+}
+
+type RotateKeyRequest struct {
+	GracePeriod int `json:"grace_period"` // Seconds until the new key takes effect
+}
+
+type KeyRotationReply struct {
+	PublicKey    string    `json:"public_key"`
+	RotationTime time.Time `json:"rotation_time"`
+}
+
 type ConnectionRequest struct {
 	PeerName  string `json:"peer_name"`
 	PublicKey string `json:"public_key"`
 }
 
 type ConnectionReply struct {
-	PublicKey       string `json:"public_key"`
-	ClientAddress   string `json:"client_address"`
-	EndpointAddress string `json:"endpoint_address"`
-	EndpointPort    int    `json:"endpoint_port"`
+	PublicKey       string   `json:"public_key"`
+	ClientAddress   string   `json:"client_address"` // First of ClientAddresses; kept for older clients
+	ClientAddresses []string `json:"client_addresses"`
+	EndpointAddress string   `json:"endpoint_address"`
+	EndpointPort    int      `json:"endpoint_port"`
+}
+
+type HeartbeatRequest struct {
+	PeerName  string `json:"peer_name"`
+	PublicKey string `json:"public_key"`
+}
+
+type HeartbeatReply struct {
+	Active          bool   `json:"active"` // Whether the peer is connected using the client's public key
+	ServerPublicKey string `json:"server_public_key"`
 }
 
 type CreatePeerRequest struct {
@@ -67,12 +116,88 @@ type CreatePeerRequest struct {
 	ServerInterface string `json:"server_interface"`
 }
 
+type ModifyPeerRequest struct {
+	Address         string `json:"address,omitempty"`
+	EndpointAddress string `json:"endpoint_address,omitempty"`
+	ServerInterface string `json:"server_interface,omitempty"`
+}
+
 type CreateUserRequest struct {
 	UserName string `json:"user_name"`
 	Password string `json:"password"`
 	IsAdmin  bool   `json:"is_admin"`
 }
 
+type ModifyUserRequest struct {
+	IsAdmin            *bool `json:"is_admin,omitempty"`
+	MaxSessionLifetime *int  `json:"max_session_lifetime,omitempty"` // Seconds; 0 means unlimited
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+}
+
+type User struct {
+	Name               string `json:"name"`
+	IsAdmin            bool   `json:"is_admin"`
+	MaxSessionLifetime int    `json:"max_session_lifetime,omitempty"` // Seconds
+}
+
+// LoginReply holds the tokens issued by /login and /login/refresh.
+// RefreshToken is only set by /login.
+type LoginReply struct {
+	AccessToken   string    `json:"access_token"`
+	AccessExpiry  time.Time `json:"access_expiry"`
+	RefreshToken  string    `json:"refresh_token,omitempty"`
+	RefreshExpiry time.Time `json:"refresh_expiry"`
+}
+
+// Token describes a login session; the tokens themselves are never listed
+type Token struct {
+	ID            string    `json:"id"`
+	User          string    `json:"user"`
+	IssuedAt      time.Time `json:"issued_at"`
+	AccessExpiry  time.Time `json:"access_expiry"`
+	RefreshExpiry time.Time `json:"refresh_expiry"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name     string   `json:"name"`
+	UserName string   `json:"user_name,omitempty"` // Administrators may create keys for other users
+	Scopes   []string `json:"scopes,omitempty"`    // Empty means unrestricted
+}
+
+// APIKey describes a long-lived key. Key is only set in the reply to its creation.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	User      string    `json:"user"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Key       string    `json:"key,omitempty"`
+}
+
+// TOTPEnrollment holds a new TOTP secret, which takes effect once a code is verified
+type TOTPEnrollment struct {
+	Secret string `json:"secret"` // Base32-encoded
+	URL    string `json:"url"`    // otpauth:// URL for authenticator apps
+}
+
+type VerifyTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// DisableTOTPRequest holds a current TOTP or recovery code
+type DisableTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// TOTPActivation holds single-use codes that can be used in place of TOTP codes
+type TOTPActivation struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
 type DisconnectionRequest struct {
 	PeerName string `json:"peer_name"`
 }
@@ -91,6 +216,17 @@ type Peer struct {
 	Address         string
 	EndpointAddress string
 	ServerInterface string
+	Owner           string      `json:",omitempty"`
+	Connected       bool        `json:",omitempty"`
+	Status          *PeerStatus `json:",omitempty"`
+}
+
+// PeerStatus holds the live WireGuard state of a connected peer
+type PeerStatus struct {
+	LastHandshakeTime time.Time
+	Endpoint          string
+	ReceiveBytes      int64
+	TransmitBytes     int64
 }
 
 func (a Address) String() string {